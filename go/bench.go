@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// History file the bench subcommand appends to and compare reads by default
+const benchHistoryPath = "bench.jsonl"
+
+// The machine a benchmark ran on, timings are only compared between runs on the same one
+type BenchMachine struct {
+	Hostname string `json:"hostname"`
+	CPU      string `json:"cpu"`
+	NumCPU   int    `json:"num_cpu"`
+	GOOS     string `json:"goos"`
+	GOARCH   string `json:"goarch"`
+}
+
+// One line of the history file, the timings of one version over the same inputs
+type BenchRecord struct {
+	Time    time.Time       `json:"time"`
+	Version string          `json:"version"`
+	Commit  string          `json:"commit,omitempty"`
+	Machine BenchMachine    `json:"machine"`
+	Inputs  []string        `json:"inputs"`
+	Bytes   int64           `json:"bytes"`
+	Rows    int64           `json:"rows"`
+	Timings []time.Duration `json:"timings_ns"`
+	Median  time.Duration   `json:"median_ns"`
+	// Top functions by flat time of an extra profiled run
+	Hotspots []Hotspot `json:"hotspots,omitempty"`
+}
+
+// A version whose latest median is slower than its previous best by more than the
+// threshold
+type Regression struct {
+	Latest BenchRecord
+	Best   BenchRecord
+	// Slowdown of the latest median relative to the best, 0.1 for 10% slower
+	Slowdown float64
+}
+
+// Model name of the CPU, falling back to the architecture when the platform doesn't
+// expose it
+func cpuModel() string {
+	switch runtime.GOOS {
+	case "linux":
+		if cpuinfo, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+			for line := range strings.Lines(string(cpuinfo)) {
+				key, value, found := strings.Cut(line, ":")
+				if found && strings.TrimSpace(key) == "model name" {
+					return strings.TrimSpace(value)
+				}
+			}
+		}
+	case "darwin":
+		if brand, err := exec.Command("sysctl", "-n", "machdep.cpu.brand_string").Output(); err == nil {
+			return strings.TrimSpace(string(brand))
+		}
+	case "windows":
+		if identifier := os.Getenv("PROCESSOR_IDENTIFIER"); identifier != "" {
+			return identifier
+		}
+	}
+	return runtime.GOARCH
+}
+
+func currentMachine() BenchMachine {
+	hostname, _ := os.Hostname()
+	return BenchMachine{Hostname: hostname, CPU: cpuModel(), NumCPU: runtime.NumCPU(), GOOS: runtime.GOOS, GOARCH: runtime.GOARCH}
+}
+
+// Commit the binary was built from, marked -dirty with uncommitted changes, asking git
+// for binaries built without the version control stamp like go run
+func currentCommit() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var revision string
+		var modified bool
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if revision != "" && modified {
+			return revision + "-dirty"
+		}
+		if revision != "" {
+			return revision
+		}
+	}
+	if revision, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		return strings.TrimSpace(string(revision))
+	}
+	return ""
+}
+
+func median(timings []time.Duration) time.Duration {
+	sorted := slices.Clone(timings)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Run the version over the inputs the given number of times
+func benchVersion(version Version, inputs []string, runs int) (BenchRecord, error) {
+	record := BenchRecord{Version: version.Name, Inputs: inputs}
+	for _, path := range inputs {
+		info, err := os.Stat(path)
+		if err != nil {
+			return record, err
+		}
+		record.Bytes += info.Size()
+	}
+
+	for range runs {
+		start := time.Now()
+		results, err := aggregateFiles(inputs, version)
+		if err != nil {
+			return record, err
+		}
+		record.Timings = append(record.Timings, time.Since(start))
+
+		var rows int64
+		for _, result := range results {
+			rows += result.rows()
+		}
+		record.Rows = rows
+	}
+	record.Median = median(record.Timings)
+	return record, nil
+}
+
+// Profile a separate run of the version so the profiler doesn't skew the timed runs
+func benchHotspots(version Version, inputs []string, top int) ([]Hotspot, error) {
+	dir, err := os.MkdirTemp("", "1brc-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	profiles, err := startProfiles([]string{"cpu"}, dir, version.Name, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	_, err = aggregateFiles(inputs, version)
+	if stopErr := profiles.stop(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	spots, err := profileHotspots(profiles.path("cpu"))
+	if err != nil {
+		return nil, err
+	}
+	return topHotspots(spots, top, hotspotFlat), nil
+}
+
+func appendBenchHistory(path string, records []BenchRecord) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+func readBenchHistory(r io.Reader) ([]BenchRecord, error) {
+	var records []BenchRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record BenchRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Compare the latest run of every version with its best earlier run on the same machine
+// and over the same amount of data, the records are expected in the order they were run
+func findRegressions(records []BenchRecord, threshold float64) []Regression {
+	type benchKey struct {
+		machine BenchMachine
+		bytes   int64
+		version string
+	}
+	groups := make(map[benchKey][]BenchRecord)
+	var keys []benchKey
+	for _, record := range records {
+		key := benchKey{record.Machine, record.Bytes, record.Version}
+		if _, found := groups[key]; !found {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], record)
+	}
+
+	var regressions []Regression
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		latest := group[len(group)-1]
+		best := slices.MinFunc(group[:len(group)-1], func(a, b BenchRecord) int {
+			return cmp.Compare(a.Median, b.Median)
+		})
+		slowdown := float64(latest.Median-best.Median) / float64(best.Median)
+		if slowdown > threshold {
+			regressions = append(regressions, Regression{Latest: latest, Best: best, Slowdown: slowdown})
+		}
+	}
+	return regressions
+}
+
+func shortCommit(commit string) string {
+	if commit == "" {
+		return "unknown commit"
+	}
+	if revision, dirty := strings.CutSuffix(commit, "-dirty"); dirty {
+		return revision[:min(len(revision), 12)] + "-dirty"
+	}
+	return commit[:min(len(commit), 12)]
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s regressed %.1f%% on %s (%d threads, %s): median %s at %s, previous best %s at %s",
+		r.Latest.Version, 100*r.Slowdown, r.Latest.Machine.CPU, r.Latest.Machine.NumCPU, r.Latest.Machine.GOOS,
+		r.Latest.Median.Round(time.Millisecond), shortCommit(r.Latest.Commit),
+		r.Best.Median.Round(time.Millisecond), shortCommit(r.Best.Commit))
+}
+
+// The bench subcommand, timing versions over the inputs and recording them to the
+// history file, or comparing the history with bench compare
+func bench(args []string) {
+	if len(args) > 0 && args[0] == "compare" {
+		benchCompare(args[1:])
+		return
+	}
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	versionNames := flags.String("versions", "V11", "comma separated versions to time, or all")
+	runs := flags.Int("runs", 5, "number of runs of each version, the median is compared")
+	historyPath := flags.String("history", benchHistoryPath, "JSON lines file the timings are appended to")
+	hotspots := flags.Int("hotspots", 5, "number of functions by flat time to record from an extra profiled run, 0 to skip it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s bench [flags] [file or glob ...]\n       %s bench compare [flags]\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *runs < 1 {
+		log.Fatalf("at least one run is needed, got %d", *runs)
+	}
+	var benched []Version
+	if strings.EqualFold(*versionNames, "all") {
+		benched = versions
+	} else {
+		for name := range strings.SplitSeq(*versionNames, ",") {
+			version, found := findVersion(strings.TrimSpace(name))
+			if !found {
+				log.Fatalf("unknown version %q", name)
+			}
+			benched = append(benched, version)
+		}
+	}
+
+	inputs := []string{measurementsPath}
+	if flags.NArg() > 0 {
+		var err error
+		if inputs, err = expandInputs(flags.Args()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	machine := currentMachine()
+	commit := currentCommit()
+	var records []BenchRecord
+	for _, version := range benched {
+		record, err := benchVersion(version, inputs, *runs)
+		if err != nil {
+			log.Fatalf("%s: %v", version.Name, err)
+		}
+		if *hotspots > 0 {
+			if record.Hotspots, err = benchHotspots(version, inputs, *hotspots); err != nil {
+				log.Fatalf("%s: %v", version.Name, err)
+			}
+		}
+		record.Time = time.Now().UTC()
+		record.Commit = commit
+		record.Machine = machine
+		records = append(records, record)
+		fmt.Printf("%s median %s over %d runs of %d rows\n", version.Name, record.Median.Round(time.Millisecond), *runs, record.Rows)
+	}
+	if err := appendBenchHistory(*historyPath, records); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// The bench compare subcommand, exiting with status 1 when a version regressed
+func benchCompare(args []string) {
+	flags := flag.NewFlagSet("bench compare", flag.ExitOnError)
+	historyPath := flags.String("history", benchHistoryPath, "JSON lines file written by the bench subcommand")
+	threshold := flags.Float64("threshold", 5, "percentage the latest median may be slower than the previous best before it's flagged")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s bench compare [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	file, err := os.Open(*historyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	records, err := readBenchHistory(file)
+	file.Close()
+	if err != nil {
+		log.Fatal(fmt.Errorf("%s: %w", *historyPath, err))
+	}
+
+	regressions := findRegressions(records, *threshold/100)
+	for _, regression := range regressions {
+		fmt.Println(regression)
+	}
+	if len(regressions) > 0 {
+		log.Fatalf("found %d regressions", len(regressions))
+	}
+	fmt.Println("No regressions")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMedian(t *testing.T) {
+	if got := median([]time.Duration{3, 1, 2}); got != 2 {
+		t.Errorf("got median %d of odd timings, want 2", got)
+	}
+	if got := median([]time.Duration{4, 1, 3, 2}); got != 2 {
+		t.Errorf("got median %d of even timings, want 2", got)
+	}
+}
+
+func TestFindRegressions(t *testing.T) {
+	laptop := BenchMachine{Hostname: "laptop", CPU: "M1", NumCPU: 8, GOOS: "darwin", GOARCH: "arm64"}
+	desktop := BenchMachine{Hostname: "desktop", CPU: "Ryzen", NumCPU: 16, GOOS: "windows", GOARCH: "amd64"}
+	record := func(machine BenchMachine, version string, commit string, median time.Duration) BenchRecord {
+		return BenchRecord{Version: version, Commit: commit, Machine: machine, Bytes: 1 << 30, Median: median}
+	}
+	history := []BenchRecord{
+		record(laptop, "V10", "a", 16*time.Second),
+		record(laptop, "V11", "a", 10*time.Second),
+		record(desktop, "V11", "a", 8*time.Second),
+		record(laptop, "V11", "b", 11*time.Second),
+		record(laptop, "V10", "b", 15*time.Second),
+		// Slower than the laptop's best but the first run on this machine
+		record(desktop, "V10", "b", 20*time.Second),
+		// 12% slower than the best, not the previous run
+		record(laptop, "V11", "c", 11200*time.Millisecond),
+		// The same version on a machine without earlier runs
+		record(BenchMachine{}, "V11", "c", time.Second),
+		// Twice as slow as the best laptop run but over twice the data
+		{Version: "V10", Commit: "c", Machine: laptop, Bytes: 2 << 30, Median: 30 * time.Second},
+	}
+
+	// Round trip through the history file
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range history {
+		if err := encoder.Encode(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read, err := readBenchHistory(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regressions := findRegressions(read, 0.1)
+	if len(regressions) != 1 {
+		t.Fatalf("got %d regressions, want 1: %v", len(regressions), regressions)
+	}
+	got := regressions[0]
+	if got.Latest.Commit != "c" || got.Best.Commit != "a" || got.Latest.Machine != laptop {
+		t.Errorf("got regression %s", got)
+	}
+	if len(findRegressions(read, 0.15)) != 0 {
+		t.Error("got a regression within the threshold")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of a station as printed by an implementation
+type printedValues struct {
+	Min  float64
+	Mean float64
+	Max  float64
+}
+
+// A city followed by its min/mean/max, the separator between the cities is optional as
+// the first Python version leaves it out and the decimal separator can be a comma as
+// the Java versions format with the default locale
+var stationPattern = regexp.MustCompile(`([^=]+)=(-?[0-9]+(?:[.,][0-9]+)?)/(-?[0-9]+(?:[.,][0-9]+)?)/(-?[0-9]+(?:[.,][0-9]+)?)`)
+
+// Parse the {city=min/mean/max, ...} line the implementations print, the last one in
+// the output as they also print their progress and timings
+func parseStations(output string) (map[string]printedValues, error) {
+	var line string
+	for candidate := range strings.Lines(output) {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "{") && strings.HasSuffix(candidate, "}") {
+			line = candidate
+		}
+	}
+	if line == "" {
+		return nil, fmt.Errorf("no {city=min/mean/max, ...} line in the output")
+	}
+
+	stations := make(map[string]printedValues)
+	for _, match := range stationPattern.FindAllStringSubmatch(line[1:len(line)-1], -1) {
+		var vals [3]float64
+		for idx, text := range match[2:] {
+			val, err := strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+			if err != nil {
+				return nil, err
+			}
+			vals[idx] = val
+		}
+		city := strings.TrimPrefix(match[1], ", ")
+		stations[city] = printedValues{Min: vals[0], Mean: vals[1], Max: vals[2]}
+	}
+	return stations, nil
+}
+
+// The differences of the stations of an implementation to the reference, one line per
+// missing, extra or differing station in the order of the cities
+func diffStations(refName string, ref map[string]printedValues, name string, got map[string]printedValues) []string {
+	cities := make([]string, 0, len(ref)+len(got))
+	for city := range ref {
+		cities = append(cities, city)
+	}
+	for city := range got {
+		if _, found := ref[city]; !found {
+			cities = append(cities, city)
+		}
+	}
+	slices.Sort(cities)
+
+	var diffs []string
+	for _, city := range cities {
+		want, inRef := ref[city]
+		have, inGot := got[city]
+		switch {
+		case !inGot:
+			diffs = append(diffs, fmt.Sprintf("%s missing in %s", city, name))
+		case !inRef:
+			diffs = append(diffs, fmt.Sprintf("%s missing in %s", city, refName))
+		case want != have:
+			var fields []string
+			for _, field := range []struct {
+				name       string
+				want, have float64
+			}{{"min", want.Min, have.Min}, {"mean", want.Mean, have.Mean}, {"max", want.Max, have.Max}} {
+				if field.want != field.have {
+					fields = append(fields, fmt.Sprintf("%s %s %.1f, %s %.1f", field.name, refName, field.want, name, field.have))
+				}
+			}
+			diffs = append(diffs, fmt.Sprintf("%s %s", city, strings.Join(fields, "; ")))
+		}
+	}
+	return diffs
+}
+
+// An implementation of the challenge run as a separate process
+type implementation struct {
+	Name string
+	Cmd  *exec.Cmd
+}
+
+// The Python and Java implementations of the repository, when their interpreters are
+// installed. They read ../1brc/measurements.txt relative to their source (Python) or
+// working directory (Java), so they're run from a temporary copy of that layout with
+// the input linked in as the measurements file
+func siblingImplementations(root string, input string, dir string, pythonCmd string, javaCmd string) ([]implementation, []string, error) {
+	absInput, err := filepath.Abs(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "1brc"), 0o755); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Symlink(absInput, filepath.Join(dir, "1brc", "measurements.txt")); err != nil {
+		return nil, nil, err
+	}
+
+	var siblings []implementation
+	var skipped []string
+	pythonSource := filepath.Join(root, "python", "main.py")
+	if _, err := os.Stat(pythonSource); err != nil {
+		skipped = append(skipped, fmt.Sprintf("python: %v", err))
+	} else if python, err := exec.LookPath(pythonCmd); err != nil {
+		skipped = append(skipped, fmt.Sprintf("python: %v", err))
+	} else {
+		source, err := os.ReadFile(pythonSource)
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(dir, "python"), 0o755)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "python", "main.py"), source, 0o644)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd := exec.Command(python, filepath.Join(dir, "python", "main.py"))
+		cmd.Dir = filepath.Join(dir, "python")
+		siblings = append(siblings, implementation{Name: "python", Cmd: cmd})
+	}
+
+	javaSource, err := filepath.Abs(filepath.Join(root, "java", "_1brc.java"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := os.Stat(javaSource); err != nil {
+		skipped = append(skipped, fmt.Sprintf("java: %v", err))
+	} else if java, err := exec.LookPath(javaCmd); err != nil {
+		skipped = append(skipped, fmt.Sprintf("java: %v", err))
+	} else {
+		if err := os.MkdirAll(filepath.Join(dir, "java"), 0o755); err != nil {
+			return nil, nil, err
+		}
+		// Run as a single source file, the decimal separator pinned to a dot
+		cmd := exec.Command(java, "-Duser.language=en", "-Duser.country=US", javaSource)
+		cmd.Dir = filepath.Join(dir, "java")
+		siblings = append(siblings, implementation{Name: "java", Cmd: cmd})
+	}
+	return siblings, skipped, nil
+}
+
+// Run the implementation, returning its stations and how long the process took including
+// its startup
+func runImplementation(impl implementation) (map[string]printedValues, time.Duration, error) {
+	var stdout, stderr bytes.Buffer
+	impl.Cmd.Stdout = &stdout
+	impl.Cmd.Stderr = &stderr
+	start := time.Now()
+	if err := impl.Cmd.Run(); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w: %s", impl.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	elapsed := time.Since(start)
+	stations, err := parseStations(stdout.String())
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", impl.Name, err)
+	}
+	return stations, elapsed, nil
+}
+
+// The compare subcommand, running a Go version and the Python and Java implementations
+// over the same input and reporting where their outputs differ
+func compare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	versionName := flags.String("version", "V11", "Go version to compare, V1 through V11")
+	root := flags.String("root", "..", "root of the repository, holding the python and java directories")
+	pythonCmd := flags.String("python", "python3", "Python interpreter to run python/main.py with, 3.12 or later")
+	javaCmd := flags.String("java", "java", "Java launcher to run java/_1brc.java with, 11 or later")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compare [flags] [file]\n", os.Args[0])
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output(), "\nThe other implementations only read plain measurements files, not compressed ones")
+	}
+	flags.Parse(args)
+
+	version, found := findVersion(*versionName)
+	if !found {
+		log.Fatalf("unknown version %q", *versionName)
+	}
+	input := measurementsPath
+	if flags.NArg() > 1 {
+		log.Fatal("compare takes a single measurements file")
+	} else if flags.NArg() == 1 {
+		input = flags.Arg(0)
+	}
+	if inputsSize([]string{input}) == 0 {
+		log.Fatalf("%s has to be an existing uncompressed measurements file", input)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	goImpl := implementation{
+		Name: "go " + version.Name,
+		Cmd:  exec.Command(executable, "-version", version.Name, "-profile", "", "-hotspots", "0", input),
+	}
+	if err := compareImplementations(goImpl, *root, input, *pythonCmd, *javaCmd); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Run the Go version and the sibling implementations over the input, printing their
+// timings and where they differ from the Go output. Returns instead of exiting so the
+// temporary layout of the siblings is always removed
+func compareImplementations(goImpl implementation, root string, input string, pythonCmd string, javaCmd string) error {
+	dir, err := os.MkdirTemp("", "1brc-compare")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	siblings, skipped, err := siblingImplementations(root, input, dir, pythonCmd, javaCmd)
+	if err != nil {
+		return err
+	}
+	implementations := append([]implementation{goImpl}, siblings...)
+	for _, reason := range skipped {
+		fmt.Printf("Skipping %s\n", reason)
+	}
+
+	var ref map[string]printedValues
+	var refElapsed time.Duration
+	var differing int
+	for idx, impl := range implementations {
+		stations, elapsed, err := runImplementation(impl)
+		if err != nil {
+			return err
+		}
+		if idx == 0 {
+			ref, refElapsed = stations, elapsed
+			fmt.Printf("%s took %s for %d stations\n", impl.Name, elapsed.Round(time.Millisecond), len(stations))
+			continue
+		}
+
+		fmt.Printf("%s took %s for %d stations, %.1fx the time of %s\n",
+			impl.Name, elapsed.Round(time.Millisecond), len(stations), elapsed.Seconds()/refElapsed.Seconds(), goImpl.Name)
+		diffs := diffStations(goImpl.Name, ref, impl.Name, stations)
+		if len(diffs) > 0 {
+			differing++
+			fmt.Printf("%s differs from %s on %d stations\n", impl.Name, goImpl.Name, len(diffs))
+		}
+		for _, diff := range diffs {
+			fmt.Printf("  %s\n", diff)
+		}
+	}
+	if differing > 0 {
+		return fmt.Errorf("%d implementations differ from %s", differing, goImpl.Name)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseStations(t *testing.T) {
+	want := map[string]printedValues{
+		"Bulawayo":   {Min: -3.5, Mean: 2.7, Max: 8.9},
+		"Hamburg":    {Min: -1, Mean: 15.1, Max: 34.2},
+		"St. John's": {Min: 0, Mean: 0, Max: 0},
+	}
+	for name, output := range map[string]string{
+		"go":        "Running calculations\n{Bulawayo=-3.5/2.7/8.9, Hamburg=-1.0/15.1/34.2, St. John's=0.0/0.0/0.0}\nTook 1ms to run\n",
+		"python v1": "Running calculations\n{Bulawayo=-3.5/2.7/8.9Hamburg=-1.0/15.1/34.2St. John's=0.0/0.0/0.0}\nElapsed time: 0.01 seconds\n",
+		"java de":   "{Bulawayo=-3,5/2,7/8,9, Hamburg=-1,0/15,1/34,2, St. John's=0,0/0,0/0,0}\r\n",
+	} {
+		got, err := parseStations(output)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+		for city, values := range want {
+			if got[city] != values {
+				t.Errorf("%s: got %s %v, want %v", name, city, got[city], values)
+			}
+		}
+	}
+
+	if _, err := parseStations("Running calculations\n"); err == nil {
+		t.Error("got no error without a result line")
+	}
+}
+
+func TestDiffStations(t *testing.T) {
+	ref := map[string]printedValues{
+		"Bulawayo": {Min: -3.5, Mean: 2.7, Max: 8.9},
+		"Hamburg":  {Min: -1, Mean: 15.1, Max: 34.2},
+		"Oslo":     {Min: 1, Mean: 1, Max: 1},
+	}
+	got := map[string]printedValues{
+		"Bulawayo": {Min: -3.5, Mean: 2.7, Max: 8.9},
+		"Hamburg":  {Min: 0, Mean: 15.2, Max: 34.2},
+		"Paris":    {Min: 1, Mean: 1, Max: 1},
+	}
+	want := []string{
+		"Hamburg min go -1.0, python 0.0; mean go 15.1, python 15.2",
+		"Oslo missing in python",
+		"Paris missing in go",
+	}
+	if diffs := diffStations("go", ref, "python", got); !slices.Equal(diffs, want) {
+		t.Errorf("got %q, want %q", diffs, want)
+	}
+}
+
+// A failing implementation fails the comparison after the temporary layout is removed
+func TestCompareCleansUp(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	input := filepath.Join(tmp, "measurements.txt")
+	os.WriteFile(input, []byte(unixMeasurements), 0o644)
+
+	failing := implementation{Name: "go V11", Cmd: exec.Command("sh", "-c", "exit 1")}
+	if err := compareImplementations(failing, t.TempDir(), input, "python3", "java"); err == nil {
+		t.Error("expected an error for the failing implementation")
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d entries in the temporary directory, want only the input", len(entries))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Cities of the random datasets, including multi-byte names, names that are prefixes of
+// each other and names only differing in case so the sort order is exercised
+var differentialCities = []string{
+	"Hamburg", "Bulawayo", "Palembang", "St. John's", "Cracow", "São Paulo", "Zürich",
+	"Ürümqi", "東京", "Abéché", "A", "Ab", "abc", "ABC", "Las Palmas de Gran Canaria",
+	"Petropavlovsk-Kamchatsky", "Yaoundé", "İzmir", "Ho Chi Minh City", "Z",
+}
+
+// Aggregate the dataset the simplest way possible, strconv for the values and a map by
+// city name, and print it in the challenge format. The means are rounded half away
+// from zero like the output of the versions
+func referenceOutput(t *testing.T, dataset string) string {
+	t.Helper()
+	type stats struct{ min, max, sum, count int64 }
+	cities := make(map[string]*stats)
+	for line := range strings.Lines(dataset) {
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			continue
+		}
+		city, value, found := strings.Cut(line, ";")
+		if !found {
+			t.Fatalf("malformed line %q", line)
+		}
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tenths := int64(math.Round(val * 10))
+		if s, found := cities[city]; found {
+			s.min, s.max, s.sum, s.count = min(s.min, tenths), max(s.max, tenths), s.sum+tenths, s.count+1
+		} else {
+			cities[city] = &stats{tenths, tenths, tenths, 1}
+		}
+	}
+
+	names := make([]string, 0, len(cities))
+	for city := range cities {
+		names = append(names, city)
+	}
+	slices.Sort(names)
+	parts := make([]string, len(names))
+	for idx, city := range names {
+		s := cities[city]
+		mean := math.Round(float64(s.sum)/float64(s.count)) / 10
+		parts[idx] = fmt.Sprintf("%s=%.1f/%.1f/%.1f", city, float64(s.min)/10, mean, float64(s.max)/10)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// A random temperature, biased towards the extremes and zero where the parsing and
+// rounding edge cases are
+func randomTemperature(rng *rand.Rand) string {
+	var tenths int
+	switch rng.IntN(10) {
+	case 0:
+		tenths = 999 - rng.IntN(3)
+	case 1:
+		tenths = -999 + rng.IntN(3)
+	case 2:
+		tenths = rng.IntN(11) - 5
+	default:
+		tenths = rng.IntN(1999) - 999
+	}
+	if tenths == 0 && rng.IntN(2) == 0 {
+		return "-0.0"
+	}
+	sign := ""
+	if tenths < 0 {
+		sign, tenths = "-", -tenths
+	}
+	return fmt.Sprintf("%s%d.%d", sign, tenths/10, tenths%10)
+}
+
+// Write the rows of the cities, one line per row
+func writeRows(builder *strings.Builder, rng *rand.Rand, cities []string, rows int) {
+	for range rows {
+		fmt.Fprintf(builder, "%s;%s\n", cities[rng.IntN(len(cities))], randomTemperature(rng))
+	}
+}
+
+// The random datasets, the edge cases first and then datasets of random sizes over a
+// random subset of the cities
+func differentialDatasets(rng *rand.Rand, random int) map[string]string {
+	datasets := make(map[string]string)
+	add := func(name string, fill func(builder *strings.Builder)) {
+		var builder strings.Builder
+		fill(&builder)
+		datasets[name] = builder.String()
+	}
+
+	add("single row", func(builder *strings.Builder) {
+		writeRows(builder, rng, differentialCities[:1], 1)
+	})
+	add("one station", func(builder *strings.Builder) {
+		writeRows(builder, rng, []string{"Hamburg"}, 500)
+	})
+	add("stations once", func(builder *strings.Builder) {
+		for _, city := range differentialCities {
+			fmt.Fprintf(builder, "%s;%s\n", city, randomTemperature(rng))
+		}
+	})
+	add("some stations once", func(builder *strings.Builder) {
+		writeRows(builder, rng, differentialCities[:3], 300)
+		for _, city := range differentialCities[3:] {
+			fmt.Fprintf(builder, "%s;%s\n", city, randomTemperature(rng))
+		}
+	})
+	add("same value", func(builder *strings.Builder) {
+		for range 50 {
+			builder.WriteString("Bulawayo;-12.3\n")
+		}
+	})
+	add("negative means", func(builder *strings.Builder) {
+		// Means just below zero, between 0 and -0.05 and exactly -0.05
+		builder.WriteString("Cracow;-0.1\nCracow;0.0\nCracow;0.0\n")
+		builder.WriteString("Oslo;-0.1\nOslo;0.0\n")
+		builder.WriteString("Zürich;0.1\nZürich;-0.2\n")
+	})
+	add("extremes", func(builder *strings.Builder) {
+		builder.WriteString("Hamburg;99.9\nHamburg;-99.9\nBulawayo;-99.9\nPalembang;99.9\n")
+	})
+	// Spans multiple chunks of the chunked versions
+	add("many rows", func(builder *strings.Builder) {
+		writeRows(builder, rng, differentialCities, 5000)
+	})
+
+	for idx := range random {
+		cities := slices.Clone(differentialCities)
+		rng.Shuffle(len(cities), func(i, j int) { cities[i], cities[j] = cities[j], cities[i] })
+		cities = cities[:1+rng.IntN(len(cities))]
+		rows := 1 + rng.IntN(200)
+		if rng.IntN(10) == 0 {
+			rows += rng.IntN(3000)
+		}
+		add(fmt.Sprintf("random %d", idx), func(builder *strings.Builder) {
+			writeRows(builder, rng, cities, rows)
+		})
+	}
+	return datasets
+}
+
+// Every version has to print the same output as the reference for every dataset
+func TestDifferential(t *testing.T) {
+	random := 200
+	if testing.Short() {
+		random = 20
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for name, dataset := range differentialDatasets(rng, random) {
+		t.Run(name, func(t *testing.T) {
+			want := referenceOutput(t, dataset)
+			for _, version := range versions {
+				result, err := version.Run(strings.NewReader(dataset))
+				if err != nil {
+					t.Fatalf("%s: %v", version.Name, err)
+				}
+				if got := formatResult(result, outputOptions); got != want {
+					if len(dataset) > 500 {
+						dataset = dataset[:500] + "..."
+					}
+					t.Fatalf("%s: got %s, want %s for the dataset\n%s", version.Name, got, want, dataset)
+				}
+			}
+		})
+	}
+}
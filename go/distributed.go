@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Number of byte ranges per worker the inputs are split into, so faster workers pick
+// up more of the ranges and a lost worker only sets back its current range
+const rangesPerWorker = 4
+
+// The largest task frame a worker accepts, far more than a path and the options take
+const maxTaskFrameSize = 64 * 1024
+
+// The largest response frame the coordinator accepts from a worker
+const maxResponseFrameSize = 1 << 30
+
+// A byte range of an input file handed to a worker. Every line belongs to the range its
+// first byte is in, so ranges can be split anywhere. End is -1 for compressed files,
+// which can only be aggregated as a whole
+type rangeTask struct {
+	Path  string
+	Start int64
+	End   int64
+}
+
+// Write a frame, the uvarint length of the payload followed by the payload
+func writeFrame(writer *bufio.Writer, payload []byte) error {
+	writer.Write(binary.AppendUvarint(nil, uint64(len(payload))))
+	writer.Write(payload)
+	return writer.Flush()
+}
+
+// Read a frame of at most limit bytes. The payload grows as it arrives instead of being
+// allocated up front, so the other side can't claim a large frame without sending it
+func readFrame(reader *bufio.Reader, limit uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > limit {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d", length, limit)
+	}
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, reader, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// Layout of a task frame, integers are varints
+//
+//	path length, path, start, end, delimiter byte, scale, lenient byte
+func encodeTask(task rangeTask, opts Options) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(task.Path)))
+	buf = append(buf, task.Path...)
+	buf = binary.AppendVarint(buf, task.Start)
+	buf = binary.AppendVarint(buf, task.End)
+	buf = append(buf, opts.Delimiter)
+	buf = binary.AppendUvarint(buf, uint64(opts.Scale))
+	if opts.Lenient {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+func decodeTask(payload []byte) (rangeTask, Options, error) {
+	var task rangeTask
+	opts := defaultOptions()
+	reader := bytes.NewReader(payload)
+
+	path, err := readSnapshotBytes(reader)
+	if err != nil {
+		return task, opts, err
+	}
+	task.Path = string(path)
+	if task.Start, err = binary.ReadVarint(reader); err != nil {
+		return task, opts, err
+	}
+	if task.End, err = binary.ReadVarint(reader); err != nil {
+		return task, opts, err
+	}
+	if opts.Delimiter, err = reader.ReadByte(); err != nil {
+		return task, opts, err
+	}
+	scale, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return task, opts, err
+	}
+	if scale > maxScale {
+		return task, opts, fmt.Errorf("scale %d is above %d", scale, maxScale)
+	}
+	opts.Scale = int(scale)
+	lenient, err := reader.ReadByte()
+	if err != nil {
+		return task, opts, err
+	}
+	opts.Lenient = lenient == 1
+	return task, opts, nil
+}
+
+// Status byte leading a response frame
+const (
+	responseOK byte = iota
+	responseError
+)
+
+// Layout of a successful response frame, integers are varints
+//
+//	0, rows, skipped count per malformed line kind, the values as a table
+//
+// A failed task responds with 1 followed by the error message
+func encodeResponse(values Result, stats Stats, scale int) []byte {
+	buf := []byte{responseOK}
+	buf = binary.AppendUvarint(buf, uint64(stats.Rows))
+	for _, count := range stats.Skipped {
+		buf = binary.AppendUvarint(buf, uint64(count))
+	}
+	table := &Table{Scale: scale, Values: values}
+	return append(buf, table.encode()...)
+}
+
+// A task the worker failed to aggregate, as opposed to losing the connection to it
+type remoteError struct {
+	message string
+}
+
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+func decodeResponse(payload []byte) (Result, Stats, error) {
+	var stats Stats
+	if len(payload) == 0 {
+		return nil, stats, io.ErrUnexpectedEOF
+	}
+	if payload[0] == responseError {
+		return nil, stats, &remoteError{message: string(payload[1:])}
+	}
+
+	reader := bytes.NewReader(payload[1:])
+	rows, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Rows = int64(rows)
+	for kind := range stats.Skipped {
+		count, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, stats, err
+		}
+		stats.Skipped[kind] = int64(count)
+	}
+	table, err := decodeTable(payload[len(payload)-reader.Len():])
+	if err != nil {
+		return nil, stats, err
+	}
+	return table.Values, stats, nil
+}
+
+// Move the offset forward to the start of the line it's in unless a line starts there,
+// the same offset for both ends of neighbouring ranges so no line is lost or counted twice
+func alignToLine(file *os.File, offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+
+	buf := make([]byte, 64*1024)
+	pos := offset - 1
+	for {
+		n, err := file.ReadAt(buf, pos)
+		if idx := bytes.IndexByte(buf[:n], '\n'); idx >= 0 {
+			return pos + int64(idx) + 1, nil
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			return pos, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Open the lines of the range, or the whole decompressed file for compressed files,
+// returning the offset of the first line
+func openRange(task rangeTask) (io.ReadCloser, int64, error) {
+	if task.End < 0 {
+		input, err := openMeasurements(task.Path)
+		return input, 0, err
+	}
+
+	file, err := os.Open(task.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	start, err := alignToLine(file, task.Start)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	end, err := alignToLine(file, task.End)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return &sectionFile{SectionReader: io.NewSectionReader(file, start, max(end-start, 0)), file: file}, start, nil
+}
+
+// Aggregate the range of a task frame, returning the response frame. The path of the
+// task is resolved in the data directory and can't point outside of it
+func runTask(payload []byte, dataDir string) []byte {
+	task, opts, err := decodeTask(payload)
+	if err != nil {
+		return append([]byte{responseError}, fmt.Sprintf("invalid task: %v", err)...)
+	}
+	if !filepath.IsLocal(task.Path) {
+		return append([]byte{responseError}, fmt.Sprintf("path %q is outside of the data directory", task.Path)...)
+	}
+
+	local := task
+	local.Path = filepath.Join(dataDir, task.Path)
+	input, start, err := openRange(local)
+	if err != nil {
+		return append([]byte{responseError}, err.Error()...)
+	}
+	defer input.Close()
+
+	values, stats, err := aggregate(input, opts)
+	if err != nil {
+		// The offset is moved to the file, the line number still counts from the start
+		// of the range as the lines before it were never read. The text of the line is
+		// left out so the contents of the files don't leave the worker
+		var lineErr *LineError
+		if errors.As(err, &lineErr) {
+			err = fmt.Errorf("line %d (offset %d): %s", lineErr.Line, lineErr.Offset+start, lineErr.Kind)
+		}
+		return append([]byte{responseError}, fmt.Sprintf("%s range %d to %d: %v", task.Path, task.Start, task.End, err)...)
+	}
+	return encodeResponse(values, stats, opts.Scale)
+}
+
+// Handle the tasks sent over the connection one after the other until it's closed
+func handleWorkerConn(conn net.Conn, dataDir string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		payload, err := readFrame(reader, maxTaskFrameSize)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := writeFrame(writer, runTask(payload, dataDir)); err != nil {
+			log.Printf("%s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// Accept coordinator connections until the listener is closed, running their tasks
+// over the files of the data directory
+func serveWorker(listener net.Listener, dataDir string) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleWorkerConn(conn, dataDir)
+	}
+}
+
+// The worker subcommand, aggregating the byte ranges a coordinator sends it
+func worker(args []string) {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:9000", "address to listen for the coordinator on, anyone reaching it can read the aggregates of the data directory")
+	dataDir := flags.String("data-dir", ".", "directory the relative paths the coordinator sends are resolved in")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s worker [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output(), "\nThe coordinator has to be given paths relative to its working directory, which every")
+		fmt.Fprintln(flags.Output(), "worker resolves in its data directory, a shared file system for remote workers")
+	}
+	flags.Parse(args)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Worker listening on %s for the files of %s", listener.Addr(), *dataDir)
+	log.Fatal(serveWorker(listener, *dataDir))
+}
+
+// Split the inputs into byte ranges, compressed files can't be split and are a single
+// range each
+func splitRanges(paths []string, parts int) ([]rangeTask, error) {
+	var tasks []rangeTask
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		decompressor, err := detectCompression(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		size := info.Size()
+		if decompressor != nil {
+			tasks = append(tasks, rangeTask{Path: path, Start: 0, End: -1})
+			continue
+		}
+		rangeSize := max((size+int64(parts)-1)/int64(parts), 1)
+		for start := int64(0); start < size; start += rangeSize {
+			tasks = append(tasks, rangeTask{Path: path, Start: start, End: min(start+rangeSize, size)})
+		}
+	}
+	return tasks, nil
+}
+
+// Connection to a worker, running one task at a time
+type workerClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dialWorker(addr string) (*workerClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &workerClient{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}, nil
+}
+
+func (c *workerClient) run(task rangeTask, opts Options) (Result, Stats, error) {
+	if err := writeFrame(c.writer, encodeTask(task, opts)); err != nil {
+		return nil, Stats{}, err
+	}
+	payload, err := readFrame(c.reader, maxResponseFrameSize)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	return decodeResponse(payload)
+}
+
+// The coordinator, splitting the inputs into byte ranges handed out to the workers at
+// the addresses and merging the values they send back. The ranges of a worker that
+// can't be reached or drops its connection go back to the other workers, while a
+// range failing on a worker, like a malformed line, fails the run
+func aggregateDistributed(paths []string, addrs []string, opts Options) (Result, Stats, error) {
+	tasks, err := splitRanges(paths, len(addrs)*rangesPerWorker)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	queue := make(chan rangeTask, len(tasks))
+	for _, task := range tasks {
+		queue <- task
+	}
+
+	var stats Stats
+	values := make(Result, 1000)
+	if len(tasks) == 0 {
+		return values, stats, nil
+	}
+
+	var mu sync.Mutex
+	remaining := len(tasks)
+	alive := len(addrs)
+	finished := false
+	var runErr error
+	done := make(chan struct{})
+	// Called with the mutex held, ending the run on the first error or once every
+	// range has been merged
+	finish := func(err error) {
+		if !finished {
+			finished = true
+			runErr = err
+			close(done)
+		}
+	}
+
+	for _, addr := range addrs {
+		go func() {
+			lost := func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				log.Printf("worker %s: %v", addr, err)
+				if alive--; alive == 0 {
+					finish(errors.New("all workers failed"))
+				}
+			}
+
+			client, err := dialWorker(addr)
+			if err != nil {
+				lost(err)
+				return
+			}
+			defer client.conn.Close()
+
+			for {
+				var task rangeTask
+				select {
+				case <-done:
+					return
+				case task = <-queue:
+				}
+
+				result, taskStats, err := client.run(task, opts)
+				var remoteErr *remoteError
+				if errors.As(err, &remoteErr) {
+					mu.Lock()
+					finish(fmt.Errorf("worker %s: %w", addr, err))
+					mu.Unlock()
+					return
+				}
+				if err != nil {
+					queue <- task
+					lost(err)
+					return
+				}
+
+				mu.Lock()
+				if !finished {
+					mergeResult(values, result)
+					stats.add(taskStats)
+					if remaining--; remaining == 0 {
+						finish(nil)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if runErr != nil {
+		return nil, Stats{}, runErr
+	}
+	return values, stats, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func startWorker(t *testing.T, dataDir string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveWorker(listener, dataDir)
+	return listener.Addr().String()
+}
+
+// Spreading the byte ranges over workers on loopback gives the same values as
+// aggregating the files locally, with the ranges of an unreachable worker picked up
+// by the others
+func TestDistributedMatchesLocal(t *testing.T) {
+	// The coordinator runs from the data directory of the workers
+	dir := t.TempDir()
+	t.Chdir(dir)
+	paths := []string{"a.txt", filepath.Join("sub", "b.txt")}
+	os.Mkdir("sub", 0o755)
+	os.WriteFile(paths[0], []byte(manyMeasurements()), 0o644)
+	os.WriteFile(paths[1], []byte(unixMeasurements), 0o644)
+
+	// Listening and closing right away leaves an address nothing listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	addrs := []string{startWorker(t, dir), startWorker(t, dir), startWorker(t, dir), closed.Addr().String()}
+
+	values, stats, err := aggregateDistributed(paths, addrs, defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(Result)
+	version, _ := findVersion("V11")
+	results, err := aggregateFiles(paths, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		mergeResult(want, result)
+	}
+	if got, want := formatResult(values, outputOptions), formatResult(want, outputOptions); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if lines := int64(strings.Count(manyMeasurements()+unixMeasurements, "\n")); stats.Rows != lines {
+		t.Errorf("got %d rows, want %d", stats.Rows, lines)
+	}
+
+	// Malformed lines fail the run with their offset in the file, but not their text
+	os.WriteFile(paths[1], []byte(unixMeasurements+"Hamburg\n"), 0o644)
+	_, _, err = aggregateDistributed(paths, addrs[:1], defaultOptions())
+	if offset := len(unixMeasurements); err == nil || !strings.Contains(err.Error(), "(offset "+strconv.Itoa(offset)+")") {
+		t.Errorf("got error %v, want the missing delimiter at offset %d", err, offset)
+	} else if strings.Contains(err.Error(), `"Hamburg"`) {
+		t.Errorf("got error %v, want the line left out", err)
+	}
+}
+
+// Workers only open the files of their data directory
+func TestWorkerDataDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "outside.txt"), []byte(unixMeasurements), 0o644)
+	dataDir := filepath.Join(dir, "data")
+	os.Mkdir(dataDir, 0o755)
+
+	for _, path := range []string{"../outside.txt", filepath.Join(dir, "outside.txt")} {
+		response := runTask(encodeTask(rangeTask{Path: path, Start: 0, End: -1}, defaultOptions()), dataDir)
+		if _, _, err := decodeResponse(response); err == nil || !strings.Contains(err.Error(), "outside of the data directory") {
+			t.Errorf("%s: got error %v, want the path rejected", path, err)
+		}
+	}
+}
+
+// Frames above the limit are rejected before reading them, and frames cut short fail
+// without allocating the length they announced
+func TestReadFrameLimit(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(bufio.NewWriter(&buf), make([]byte, 100))
+	if _, err := readFrame(bufio.NewReader(&buf), 10); err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("got error %v, want the frame over the limit", err)
+	}
+
+	short := append(binary.AppendUvarint(nil, maxTaskFrameSize), "abc"...)
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(short)), maxTaskFrameSize); err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v, want an unexpected EOF", err)
+	}
+}
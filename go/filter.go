@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matches city names against an exact set of names, a prefix or a regular expression,
+// a city matches when any of the configured conditions matches
+type cityMatcher struct {
+	names  map[string]bool
+	prefix string
+	regexp *regexp.Regexp
+}
+
+func (m *cityMatcher) empty() bool {
+	return m.names == nil && m.prefix == "" && m.regexp == nil
+}
+
+// Only an exact set of names is configured, which the workers can check directly
+func (m *cityMatcher) exact() bool {
+	return m.names != nil && m.prefix == "" && m.regexp == nil
+}
+
+func (m *cityMatcher) match(city string) bool {
+	if m.names[city] {
+		return true
+	}
+	if m.prefix != "" && strings.HasPrefix(city, m.prefix) {
+		return true
+	}
+	return m.regexp != nil && m.regexp.MatchString(city)
+}
+
+// Include and exclude filters for the cities in the output. A city is kept when it
+// matches the include filter, or there is none, and doesn't match the exclude filter
+type CityFilter struct {
+	Include cityMatcher
+	Exclude cityMatcher
+}
+
+func (f *CityFilter) keep(city string) bool {
+	if f == nil {
+		return true
+	}
+	if !f.Include.empty() && !f.Include.match(city) {
+		return false
+	}
+	return f.Exclude.empty() || !f.Exclude.match(city)
+}
+
+// Filter the output and, unless the aggregated values outlive the run, the aggregation
+// as well so the V11 workers can skip the filtered out cities. Snapshots and saved
+// tables keep every city for the later runs and merges, so they're only filtered when
+// printed
+func (f *CityFilter) apply(opts *Options, outputOpts *OutputOptions, keepAll bool) {
+	if !keepAll {
+		opts.Filter = f
+	}
+	outputOpts.Filter = f
+}
+
+// When the filter is made up of exact names only, the set of names the workers can
+// check before hashing and updating a city. The returned include flag tells whether
+// the cities in the set are kept or skipped
+func (f *CityFilter) exactSet() (names map[string]bool, include bool, ok bool) {
+	if f == nil {
+		return nil, false, false
+	}
+
+	switch {
+	case f.Include.exact() && (f.Exclude.empty() || f.Exclude.exact()):
+		names = make(map[string]bool, len(f.Include.names))
+		for name := range f.Include.names {
+			if !f.Exclude.names[name] {
+				names[name] = true
+			}
+		}
+		return names, true, true
+	case f.Include.empty() && f.Exclude.exact():
+		return f.Exclude.names, false, true
+	}
+	return nil, false, false
+}
+
+// Read the city names of a filter list, one city per line with blank lines ignored
+func readCityList(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if len(name) == 0 {
+			continue
+		}
+		names[name] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Build a matcher from the list file, prefix and regular expression flags, any of
+// which may be empty
+func newCityMatcher(listPath string, prefix string, expr string) (cityMatcher, error) {
+	matcher := cityMatcher{prefix: prefix}
+	if listPath != "" {
+		names, err := readCityList(listPath)
+		if err != nil {
+			return cityMatcher{}, err
+		}
+		matcher.names = names
+	}
+	if expr != "" {
+		compiled, err := regexp.Compile(expr)
+		if err != nil {
+			return cityMatcher{}, err
+		}
+		matcher.regexp = compiled
+	}
+	return matcher, nil
+}
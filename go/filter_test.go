@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// One city per line, surrounding spaces, CRLF line endings and blank lines are ignored
+func TestReadCityList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cities.txt")
+	os.WriteFile(path, []byte("Hamburg\r\n\r\n  Bulawayo \nSão Paulo\n\n"), 0o644)
+
+	names, err := readCityList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || !names["Hamburg"] || !names["Bulawayo"] || !names["São Paulo"] {
+		t.Errorf("got %v", names)
+	}
+
+	if _, err := readCityList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error for a missing list")
+	}
+}
+
+func TestCityFilter(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "cities.txt")
+	os.WriteFile(list, []byte("Hamburg\nBulawayo\n"), 0o644)
+	cities := []string{"Hamburg", "Hamm", "Bulawayo", "Palembang", "São Paulo"}
+
+	tests := []struct {
+		name     string
+		include  [3]string
+		exclude  [3]string
+		wantKept string
+	}{
+		{"none", [3]string{}, [3]string{}, "Hamburg,Hamm,Bulawayo,Palembang,São Paulo"},
+		{"include prefix", [3]string{"", "Ham", ""}, [3]string{}, "Hamburg,Hamm"},
+		{"include regexp", [3]string{"", "", "^[BP]"}, [3]string{}, "Bulawayo,Palembang"},
+		{"include list", [3]string{list, "", ""}, [3]string{}, "Hamburg,Bulawayo"},
+		{"include list or prefix", [3]string{list, "P", ""}, [3]string{}, "Hamburg,Bulawayo,Palembang"},
+		{"exclude regexp", [3]string{}, [3]string{"", "", "^[BP]"}, "Hamburg,Hamm,São Paulo"},
+		{"exclude prefix", [3]string{}, [3]string{"", "Ham", ""}, "Bulawayo,Palembang,São Paulo"},
+		{"include prefix exclude list", [3]string{"", "Ham", ""}, [3]string{list, "", ""}, "Hamm"},
+		{"include regexp exclude prefix", [3]string{"", "", "m"}, [3]string{"", "Hamb", ""}, "Hamm,Palembang"},
+	}
+	for _, test := range tests {
+		var filter CityFilter
+		var err error
+		if filter.Include, err = newCityMatcher(test.include[0], test.include[1], test.include[2]); err != nil {
+			t.Fatal(err)
+		}
+		if filter.Exclude, err = newCityMatcher(test.exclude[0], test.exclude[1], test.exclude[2]); err != nil {
+			t.Fatal(err)
+		}
+
+		var kept []string
+		for _, city := range cities {
+			if filter.keep(city) {
+				kept = append(kept, city)
+			}
+		}
+		if got := strings.Join(kept, ","); got != test.wantKept {
+			t.Errorf("%s: kept %s, want %s", test.name, got, test.wantKept)
+		}
+	}
+
+	if _, err := newCityMatcher("", "", "("); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	var filter *CityFilter
+	if !filter.keep("Hamburg") {
+		t.Error("a nil filter has to keep every city")
+	}
+}
+
+// Only filters of exact names can be checked by the workers, the excluded names are
+// taken out of the included ones
+func TestExactSet(t *testing.T) {
+	filter := &CityFilter{
+		Include: cityMatcher{names: map[string]bool{"Hamburg": true, "Bulawayo": true}},
+		Exclude: cityMatcher{names: map[string]bool{"Bulawayo": true}},
+	}
+	names, include, ok := filter.exactSet()
+	if !ok || !include || len(names) != 1 || !names["Hamburg"] {
+		t.Errorf("got %v include %t ok %t, want only Hamburg included", names, include, ok)
+	}
+
+	filter.Include = cityMatcher{}
+	if names, include, ok = filter.exactSet(); !ok || include || !names["Bulawayo"] {
+		t.Errorf("got %v include %t ok %t, want Bulawayo excluded", names, include, ok)
+	}
+
+	filter.Include = cityMatcher{prefix: "Ham"}
+	if _, _, ok = filter.exactSet(); ok {
+		t.Error("a prefix can't be checked as an exact set")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the followed file is checked for appended bytes
+const followPollInterval = 250 * time.Millisecond
+
+// Follows a measurements file like tail -f, aggregating the complete lines appended to
+// it with the same chunk aggregation the V11 workers use
+type follower struct {
+	path string
+	file *os.File
+	// Offset up to which the current file has been read
+	read int64
+	// Offset and line number of the first byte that hasn't been aggregated, the start
+	// of the partial line
+	offset  int64
+	line    int64
+	partial []byte
+
+	mu         sync.Mutex
+	aggregator *chunkAggregator
+	changed    bool
+}
+
+func newFollower(path string, opts Options) *follower {
+	return &follower{path: path, line: 1, aggregator: newChunkAggregator(make(map[int64]*ValuesV3, 1000), opts)}
+}
+
+// Start over at the beginning of the file, the partial line belonged to the previous
+// contents
+func (f *follower) reset() {
+	f.read, f.offset, f.line = 0, 0, 1
+	f.partial = nil
+}
+
+// Check the file for appended bytes, switching over to the new file when it was
+// rotated and starting over when it was truncated
+func (f *follower) poll() error {
+	info, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The file is being rotated, pick up the new file on one of the next polls
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.file != nil {
+		current, err := f.file.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(current, info) {
+			// Rotated, aggregate what's left of the old file before switching over. Its
+			// last line is complete even without a newline as nothing writes to it anymore
+			if err := f.readNew(); err != nil {
+				return err
+			}
+			if len(f.partial) > 0 {
+				if err := f.aggregate([]byte{'\n'}); err != nil {
+					return err
+				}
+			}
+			f.file.Close()
+			f.file = nil
+		}
+	}
+
+	if f.file == nil {
+		if f.file, err = os.Open(f.path); err != nil {
+			return err
+		}
+		f.reset()
+	}
+
+	if info.Size() < f.read {
+		f.reset()
+	}
+	return f.readNew()
+}
+
+// Read the bytes appended since the last poll and aggregate their complete lines
+func (f *follower) readNew() error {
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := f.file.ReadAt(buf, f.read)
+		if n > 0 {
+			f.read += int64(n)
+			if err := f.aggregate(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Aggregate the complete lines of the partial line followed by the data, keeping the
+// incomplete last line until its newline is appended
+func (f *follower) aggregate(data []byte) error {
+	data = append(f.partial, data...)
+	idx := bytes.LastIndexByte(data, '\n')
+	if idx < 0 {
+		f.partial = data
+		return nil
+	}
+
+	lines := data[:idx]
+	f.mu.Lock()
+	lineErr := f.aggregator.add(chunk{data: lines, offset: f.offset, line: f.line})
+	f.changed = true
+	f.mu.Unlock()
+	if lineErr != nil {
+		return lineErr
+	}
+
+	f.offset += int64(idx) + 1
+	f.line += int64(bytes.Count(lines, []byte("\n"))) + 1
+	f.partial = append([]byte{}, data[idx+1:]...)
+	return nil
+}
+
+// Current values of the followed file, printed when anything changed since the last call
+func (f *follower) printChanges(opts OutputOptions) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.changed {
+		return
+	}
+	f.changed = false
+	fmt.Printf("%s %s\n", time.Now().Format(time.TimeOnly), formatResult(f.aggregator.output, opts))
+}
+
+func (f *follower) handleResults(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseOutputQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	stations := finalize(f.aggregator.output, opts)
+	rows := f.aggregator.stats.Rows
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Rows     int64     `json:"rows"`
+		Stations []Station `json:"stations"`
+	}{rows, stations})
+}
+
+// Follow the file until the process is stopped, printing the current values every
+// interval and serving them as JSON on /results when an address is given
+func follow(path string, interval time.Duration, addr string) {
+	f := newFollower(path, options)
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /results", f.handleResults)
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, mux))
+		}()
+	}
+
+	poll := time.NewTicker(followPollInterval)
+	defer poll.Stop()
+	report := time.NewTicker(interval)
+	defer report.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			if err := f.poll(); err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+		case <-report.C:
+			f.printChanges(outputOptions)
+		}
+	}
+}
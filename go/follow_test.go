@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The follower only aggregates complete lines and keeps its values across truncation
+// and rotation of the followed file
+func TestFollowAppendTruncateRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	f := newFollower(path, defaultOptions())
+
+	steps := []struct {
+		write func()
+		want  string
+	}{
+		// The partial last line waits for its newline
+		{func() { os.WriteFile(path, []byte("Hamburg;1.0\nBulawayo;8.9\nPalembang;3"), 0o644) }, "{Bulawayo=8.9/8.9/8.9, Hamburg=1.0/1.0/1.0}"},
+		{func() { appendFile(t, path, "8.8\nHamburg;3.0\n") }, "{Bulawayo=8.9/8.9/8.9, Hamburg=1.0/2.0/3.0, Palembang=38.8/38.8/38.8}"},
+		// Truncated, reading starts over at the beginning
+		{func() { os.WriteFile(path, []byte("Hamburg;5.0\n"), 0o644) }, "{Bulawayo=8.9/8.9/8.9, Hamburg=1.0/3.0/5.0, Palembang=38.8/38.8/38.8}"},
+		// Rotated, the rest of the old file is read before switching to the new one
+		{func() {
+			appendFile(t, path, "Bulawayo;-1.1")
+			os.Rename(path, path+".1")
+			os.WriteFile(path, []byte("Cracow;12.6\n"), 0o644)
+		}, "{Bulawayo=-1.1/3.9/8.9, Cracow=12.6/12.6/12.6, Hamburg=1.0/3.0/5.0, Palembang=38.8/38.8/38.8}"},
+	}
+
+	for idx, step := range steps {
+		step.write()
+		if err := f.poll(); err != nil {
+			t.Fatalf("step %d: %v", idx, err)
+		}
+		if got := formatResult(f.aggregator.output, outputOptions); got != step.want {
+			t.Errorf("step %d: got %s, want %s", idx, got, step.want)
+		}
+	}
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
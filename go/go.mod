@@ -1,3 +1,9 @@
 module 1brc
 
 go 1.25.4
+
+require (
+	github.com/google/pprof v0.0.0-20260115054156-294ebfa9ad83
+	golang.org/x/sync v0.22.0
+	golang.org/x/text v0.40.0
+)
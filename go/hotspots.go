@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// CPU time spent in a function, flat in the function itself and cumulative including
+// the functions it called
+type Hotspot struct {
+	Name string        `json:"name"`
+	Flat time.Duration `json:"flat_ns"`
+	Cum  time.Duration `json:"cum_ns"`
+}
+
+func hotspotFlat(spot Hotspot) time.Duration { return spot.Flat }
+func hotspotCum(spot Hotspot) time.Duration  { return spot.Cum }
+
+// The top functions by the metric, leaving out the ones that didn't take any time
+func topHotspots(spots []Hotspot, top int, metric func(Hotspot) time.Duration) []Hotspot {
+	sorted := slices.Clone(spots)
+	slices.SortFunc(sorted, func(a, b Hotspot) int {
+		return cmp.Or(cmp.Compare(metric(b), metric(a)), strings.Compare(a.Name, b.Name))
+	})
+	sorted = sorted[:min(top, len(sorted))]
+	for idx, spot := range sorted {
+		if metric(spot) == 0 {
+			return sorted[:idx]
+		}
+	}
+	return sorted
+}
+
+// Read the CPU profile and sum the flat and cumulative time of every function, the
+// same way the pprof top view does
+func profileHotspots(path string) ([]Hotspot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	prof, err := profile.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	valueIdx := -1
+	for idx, sampleType := range prof.SampleType {
+		if sampleType.Type == "cpu" && sampleType.Unit == "nanoseconds" {
+			valueIdx = idx
+		}
+	}
+	if valueIdx < 0 {
+		return nil, fmt.Errorf("%s is not a CPU profile", path)
+	}
+
+	hotspots := make(map[string]*Hotspot)
+	hotspot := func(name string) *Hotspot {
+		spot, found := hotspots[name]
+		if !found {
+			spot = &Hotspot{Name: name}
+			hotspots[name] = spot
+		}
+		return spot
+	}
+	for _, sample := range prof.Sample {
+		value := time.Duration(sample.Value[valueIdx])
+		// The first line of the first location is the function the sample was taken in,
+		// the inlined functions come first within a location
+		seen := make(map[string]bool)
+		for locIdx, location := range sample.Location {
+			for lineIdx, line := range location.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				if locIdx == 0 && lineIdx == 0 {
+					hotspot(name).Flat += value
+				}
+				// Recursive functions only count once per sample
+				if !seen[name] {
+					seen[name] = true
+					hotspot(name).Cum += value
+				}
+			}
+		}
+	}
+
+	spots := make([]Hotspot, 0, len(hotspots))
+	for _, spot := range hotspots {
+		spots = append(spots, *spot)
+	}
+	return spots, nil
+}
+
+// Format a duration like the timings of the version notes, 37seconds, with milliseconds
+// for the functions of short runs
+func formatSeconds(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%dseconds", int64(d.Round(time.Second).Seconds()))
+}
+
+// The top functions by flat and by cumulative time, one "function Nseconds" line each so
+// they can be pasted into the version notes
+func formatHotspots(spots []Hotspot, top int) string {
+	var builder strings.Builder
+	for _, metric := range []struct {
+		title string
+		value func(Hotspot) time.Duration
+	}{
+		{"flat", hotspotFlat},
+		{"cumulative", hotspotCum},
+	} {
+		fmt.Fprintf(&builder, "Top functions by %s time\n", metric.title)
+		for _, spot := range topHotspots(spots, top, metric.value) {
+			fmt.Fprintf(&builder, "%s %s\n", spot.Name, formatSeconds(metric.value(spot)))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestProfileHotspots(t *testing.T) {
+	functions := map[string]*profile.Function{}
+	location := func(names ...string) *profile.Location {
+		loc := &profile.Location{ID: uint64(len(functions) + 1)}
+		for _, name := range names {
+			if functions[name] == nil {
+				functions[name] = &profile.Function{ID: uint64(len(functions) + 1), Name: name}
+			}
+			loc.Line = append(loc.Line, profile.Line{Function: functions[name]})
+		}
+		return loc
+	}
+	v3 := location("main.V3")
+	scan := location("bufio.(*Scanner).Scan")
+	// ParseFloat inlined into readFloat, which counts as the flat function
+	parse := location("internal/strconv.readFloat", "strconv.ParseFloat")
+
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{parse, v3}, Value: []int64{1, int64(16 * time.Second)}},
+			{Location: []*profile.Location{scan, v3}, Value: []int64{1, int64(8 * time.Second)}},
+			{Location: []*profile.Location{v3}, Value: []int64{1, int64(2 * time.Second)}},
+		},
+		Location: []*profile.Location{v3, scan, parse},
+	}
+	for _, function := range functions {
+		prof.Function = append(prof.Function, function)
+	}
+
+	path := filepath.Join(t.TempDir(), "cpu-V3.prof")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prof.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	spots, err := profileHotspots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Top functions by flat time\n" +
+		"internal/strconv.readFloat 16seconds\n" +
+		"bufio.(*Scanner).Scan 8seconds\n" +
+		"Top functions by cumulative time\n" +
+		"main.V3 26seconds\n" +
+		"internal/strconv.readFloat 16seconds\n"
+	if got := formatHotspots(spots, 2); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := profileHotspots(filepath.Join(t.TempDir(), "missing.prof")); err == nil {
+		t.Error("got no error for a missing profile")
+	}
+}
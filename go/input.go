@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Default location of the generated measurements file, shared with the other languages
+const measurementsPath = "../1brc/measurements.txt"
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// Wraps the decompressed side of the pipe so closing the input also closes the file
+// and stops the decompression goroutine
+type decompressedFile struct {
+	*io.PipeReader
+	file *os.File
+}
+
+func (d *decompressedFile) Close() error {
+	d.PipeReader.Close()
+	return d.file.Close()
+}
+
+// Detect gzip or bzip2 compressed input by the magic bytes at the start of the reader,
+// returning a reader decompressing the stream or nil for plain text
+func detectCompression(reader *bufio.Reader) (io.Reader, error) {
+	magic, err := reader.Peek(len(bzip2Magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(reader)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(reader), nil
+	}
+	return nil, nil
+}
+
+// Run the decompression on its own goroutine and hand it over through a pipe so the
+// scanner and workers reading from the returned reader stay busy while the next block
+// is inflated. Closing the returned reader stops the goroutine
+func decompressAsync(decompressor io.Reader) *io.PipeReader {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		buf := make([]byte, 1024*1024)
+		_, err := io.CopyBuffer(pipeWriter, decompressor, buf)
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
+
+// Opens the measurements file at the given path, detecting gzip or bzip2 compressed
+// files by their magic bytes and decompressing them as a stream
+func openMeasurements(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor, err := detectCompression(bufio.NewReaderSize(file, 1024*1024))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if decompressor == nil {
+		// Plain text, hand back the file as is so uncompressed runs are untouched
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	return &decompressedFile{PipeReader: decompressAsync(decompressor), file: file}, nil
+}
+
+// Tracks the position of the lines a scanner splits off, so the line by line versions
+// can report where a malformed line is. The offsets include the \r of CRLF line endings
+// the scanner drops from the lines
+type linePosition struct {
+	Line   int64
+	Offset int64
+	next   int64
+}
+
+// The bufio.ScanLines split function, recording the position of every line returned
+func (p *linePosition) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil {
+		p.Line++
+		p.Offset = p.next
+	}
+	p.next += int64(advance)
+	return advance, token, err
+}
+
+// Expand the file arguments into the list of files to process, arguments containing
+// glob patterns are expanded into every matching file in sorted order
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") {
+			inputs = append(inputs, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		inputs = append(inputs, matches...)
+	}
+	return inputs, nil
+}
+
+// Ends the input at the end of the current line once the context is cancelled, so every
+// version stops early with the complete lines read so far instead of failing on a line
+// that was cut off. The bytes handed out are counted to report how far a run got, and
+// added to the progress along with the lines when it's tracked
+type cancelReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	progress *Progress
+	read     int64
+	// The last byte handed out ended a line, or nothing was handed out yet
+	lineStart bool
+	done      bool
+}
+
+func newCancelReader(ctx context.Context, input io.ReadCloser, progress *Progress) *cancelReader {
+	return &cancelReader{ReadCloser: input, ctx: ctx, progress: progress, lineStart: true}
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if c.done || (c.lineStart && c.ctx.Err() != nil) {
+		c.done = true
+		return 0, io.EOF
+	}
+
+	n, err := c.ReadCloser.Read(p)
+	if c.ctx.Err() != nil {
+		if idx := bytes.IndexByte(p[:n], '\n'); idx >= 0 {
+			n, err, c.done = idx+1, nil, true
+		}
+	}
+	if n > 0 {
+		c.lineStart = p[n-1] == '\n'
+	}
+	c.read += int64(n)
+	if c.progress != nil {
+		c.progress.Bytes.Add(int64(n))
+		c.progress.Lines.Add(int64(bytes.Count(p[:n], []byte("\n"))))
+	}
+	return n, err
+}
+
+// Run the version over every file concurrently, returning the result of each file
+// in the same order as the given paths
+func aggregateFiles(paths []string, version Version) ([]Result, error) {
+	return aggregateInputs(paths, func(idx int) (io.ReadCloser, error) {
+		return openMeasurements(paths[idx])
+	}, version)
+}
+
+// Run the version over every file like aggregateFiles, stopping at the end of the
+// current lines once the context is cancelled. Returns the number of decompressed bytes
+// read from the files as well, which are also added to the progress unless it's nil
+func aggregateFilesContext(ctx context.Context, paths []string, version Version, progress *Progress) ([]Result, int64, error) {
+	readers := make([]*cancelReader, len(paths))
+	results, err := aggregateInputs(paths, func(idx int) (io.ReadCloser, error) {
+		input, err := openMeasurements(paths[idx])
+		if err != nil {
+			return nil, err
+		}
+		readers[idx] = newCancelReader(ctx, input, progress)
+		return readers[idx], nil
+	}, version)
+
+	var read int64
+	for _, reader := range readers {
+		if reader != nil {
+			read += reader.read
+		}
+	}
+	return results, read, err
+}
+
+// Run the version over every input concurrently, returning the result of each input
+// in order. At most one input per CPU thread is open at a time as the later versions
+// already spread a single input over multiple workers. The first input failing to
+// open or aggregate fails the run, with the error prefixed by the name of the input
+func aggregateInputs(names []string, open func(idx int) (io.ReadCloser, error), version Version) ([]Result, error) {
+	var group errgroup.Group
+	group.SetLimit(runtime.NumCPU())
+	results := make([]Result, len(names))
+
+	for idx := range names {
+		group.Go(func() error {
+			input, err := open(idx)
+			if err != nil {
+				return err
+			}
+			defer input.Close()
+
+			if results[idx], err = version.Run(input); err != nil {
+				return fmt.Errorf("%s: %w", names[idx], err)
+			}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
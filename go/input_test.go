@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Cancels the context once the reader got past the given number of bytes
+type cancelAfter struct {
+	reader io.Reader
+	after  int
+	read   int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if c.read += n; c.read > c.after {
+		c.cancel()
+	}
+	return n, err
+}
+
+// A cancelled input ends at the end of the current line, so every version returns the
+// complete lines read so far
+func TestCancelReaderStopsAtLineEnd(t *testing.T) {
+	input := manyMeasurements()
+	for _, version := range versions {
+		ctx, cancel := context.WithCancel(context.Background())
+		// Read a byte at a time so the cancellation lands in the middle of a line
+		source := &cancelAfter{reader: iotest.OneByteReader(strings.NewReader(input)), after: 1005, cancel: cancel}
+		// Without progress reporting only the bytes are counted
+		reader := newCancelReader(ctx, io.NopCloser(source), nil)
+		result, err := version.Run(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", version.Name, err)
+		}
+		read := reader.read
+
+		if read >= int64(len(input)) || input[read-1] != '\n' {
+			t.Errorf("%s: read %d bytes, want to stop at the end of a line", version.Name, read)
+		}
+		// Every line handed out is aggregated, including the chunks still queued for the
+		// workers of the chunked versions
+		if rows, want := result.rows(), int64(strings.Count(input[:read], "\n")); rows != want {
+			t.Errorf("%s: got %d rows from %d lines", version.Name, rows, want)
+		}
+	}
+}
+
+// The bytes and lines handed out are added to the progress when it's tracked
+func TestCancelReaderProgress(t *testing.T) {
+	progress := newProgress(int64(len(unixMeasurements)))
+	reader := newCancelReader(context.Background(), io.NopCloser(strings.NewReader(unixMeasurements)), progress)
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Bytes.Load() != int64(len(unixMeasurements)) || progress.Lines.Load() != 6 || reader.read != progress.Bytes.Load() {
+		t.Errorf("got %d bytes and %d lines, read %d", progress.Bytes.Load(), progress.Lines.Load(), reader.read)
+	}
+}
+
+// The measurements of TestOpenMeasurements compressed with bzip2 -9, the standard
+// library can't write bzip2
+const bzip2Measurements = "" +
+	"\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x24\xe6\x03\xd7\x00\x00" +
+	"\x13\x5f\x80\x00\x10\x00\x03\x7e\x68\x10\x40\x40\x00\x32\x87\x92" +
+	"\xa0\x20\x00\x48\x68\x46\x91\xa7\xea\x83\x10\xd0\x61\xa5\x18\x00" +
+	"\x00\x01\xe3\x9a\xcf\x42\x1e\x64\xc8\x10\x72\x69\x42\x01\xe2\x15" +
+	"\x9a\xd7\x54\x5d\x82\x52\x89\x6e\x87\xc2\xe0\x37\xa5\xbe\x0d\xf3" +
+	"\xe0\x3a\x0c\x5d\x0d\x23\x81\xe1\x77\x24\x53\x85\x09\x02\x4e\x60" +
+	"\x3d\x70"
+
+// Compressed files are decompressed by their magic bytes and files shorter than the
+// magic bytes are read as plain text
+func TestOpenMeasurements(t *testing.T) {
+	const measurements = "Hamburg;12.0\nBulawayo;8.9\nPalembang;38.8\nHamburg;34.2\nBulawayo;-3.5\nHamburg;-1.0\n"
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	writer.Write([]byte(measurements))
+	writer.Close()
+
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name string
+		data string
+		want string
+	}{
+		{"plain.txt", measurements, measurements},
+		{"measurements.txt.gz", gzipped.String(), measurements},
+		{"measurements.txt.bz2", bzip2Measurements, measurements},
+		{"empty.txt", "", ""},
+		{"short.txt", "A\n", "A\n"},
+		{"gzip-prefix.txt", "\x1f", "\x1f"},
+	} {
+		path := filepath.Join(dir, test.name)
+		os.WriteFile(path, []byte(test.data), 0o644)
+		input, err := openMeasurements(path)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got, err := io.ReadAll(input)
+		input.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Glob patterns expand into the matching files in sorted order next to the plain paths,
+// a pattern without any match fails instead of being skipped
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt", "c.csv"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+
+	got, err := expandInputs([]string{filepath.Join(dir, "*.txt"), "plain.txt", filepath.Join(dir, "[c].csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), "plain.txt", filepath.Join(dir, "c.csv")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	pattern := filepath.Join(dir, "*.gz")
+	if _, err := expandInputs([]string{pattern}); err == nil || err.Error() != `no files match "`+pattern+`"` {
+		t.Errorf("got error %v, want no files matching %s", err, pattern)
+	}
+	if _, err := expandInputs([]string{"[a"}); err == nil || !strings.HasPrefix(err.Error(), "invalid glob") {
+		t.Errorf("got error %v, want an invalid glob", err)
+	}
+}
@@ -3,45 +3,318 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"log"
-	"math"
 	"os"
+	"os/signal"
 	"runtime"
-	"runtime/pprof"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// A complete version of the challenge, reading the measurements from the reader
+// and returning the aggregated values per city, or the error reading or parsing them
+type Version struct {
+	Name string
+	Run  func(r io.Reader) (Result, error)
+}
+
+// All the complete versions in order, V12 is still a work in progress and left out
+var versions = []Version{
+	{"V1", V1},
+	{"V2", V2},
+	{"V3", V3},
+	{"V4", V4},
+	{"V5", V5},
+	{"V6", V6},
+	{"V7", V7},
+	{"V8", V8},
+	{"V9", V9},
+	{"V10", V10},
+	{"V11", V11},
+}
+
+func findVersion(name string) (Version, bool) {
+	for _, version := range versions {
+		if strings.EqualFold(version.Name, name) {
+			return version, true
+		}
+	}
+	return Version{}, false
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		worker(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		report(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compare(os.Args[2:])
+		return
+	}
+
+	versionName := flag.String("version", "V11", "version to run, V1 through V11")
+	perFile := flag.Bool("per-file", false, "also print the results of each input file")
+	flag.BoolVar(&options.Lenient, "lenient", false, "skip and count malformed lines instead of failing on the first one (V11)")
+	delimiter := flag.String("delimiter", ";", "separator between the city and the temperature, \\t for tabs (V11)")
+	flag.IntVar(&options.Scale, "scale", 1, fmt.Sprintf("number of fractional digits of the temperatures, 0 through %d (V11)", maxScale))
+	unit := flag.String("unit", "C", "unit of the output temperatures, C, F or K")
+	includeFile := flag.String("include-file", "", "only output the cities listed in the file, one per line")
+	includePrefix := flag.String("include-prefix", "", "only output the cities starting with the prefix")
+	includeRegexp := flag.String("include-regexp", "", "only output the cities matching the regular expression")
+	excludeFile := flag.String("exclude-file", "", "leave out the cities listed in the file, one per line")
+	excludePrefix := flag.String("exclude-prefix", "", "leave out the cities starting with the prefix")
+	excludeRegexp := flag.String("exclude-regexp", "", "leave out the cities matching the regular expression")
+	rank := flag.String("rank", "mean", "metric to rank the cities by with -top or -bottom, min, mean, max or range")
+	flag.IntVar(&outputOptions.Top, "top", 0, "only output the given number of cities with the highest -rank metric")
+	flag.IntVar(&outputOptions.Bottom, "bottom", 0, "only output the given number of cities with the lowest -rank metric")
+	sortOrder := flag.String("sort", "byte", "order of the cities, byte, codepoint, locale or one of the -rank metrics")
+	locale := flag.String("locale", "en", "locale whose collation rules are used with -sort locale")
+	descending := flag.Bool("desc", false, "sort the cities in descending order")
+	snapshotPath := flag.String("snapshot", "", "snapshot file to fold only new files and appended lines into, created when missing")
+	followFile := flag.Bool("follow", false, "keep reading the lines appended to the file, like tail -f, and print the current values")
+	followInterval := flag.Duration("follow-interval", 5*time.Second, "how often -follow prints the current values when they changed")
+	followAddr := flag.String("follow-addr", "", "address to serve the current values of -follow on as JSON at /results")
+	windowSize := flag.String("window", "", "aggregate timestamp;city;temp lines per city and hour, day or month (V11)")
+	windowZone := flag.String("window-tz", "UTC", "time zone whose wall clock the -window buckets follow")
+	windowOffset := flag.Duration("window-offset", 0, "shift the start of the -window buckets, 6h for days from 06:00 to 06:00")
+	timestampLayout := flag.String("timestamp-layout", time.RFC3339, "Go time layout of the -window timestamps, or unix for epoch seconds")
+	savePath := flag.String("save", "", "save the aggregated values as a table file, which -merge can merge later")
+	mergeInputs := flag.Bool("merge", false, "merge the table files saved with -save given as the inputs instead of aggregating measurements")
+	showProgress := flag.Bool("progress", false, "show the bytes read, rows per second, ETA and V11 worker throughput on stderr")
+	workerAddrs := flag.String("workers", "", "comma separated addresses of worker processes to spread the inputs over (V11)")
+	profileList := flag.String("profile", "cpu", "comma separated profiles to record as <kind>-<version>.prof, cpu, heap, allocs, block, mutex or trace (written as trace-<version>.out)")
+	profileDir := flag.String("profile-dir", ".", "directory the -profile files are written to")
+	blockRate := flag.Int("block-rate", 1, "record one blocking event per this many nanoseconds blocked with -profile block")
+	mutexFraction := flag.Int("mutex-fraction", 1, "record one in this many mutex contention events with -profile mutex")
+	hotspots := flag.Int("hotspots", 5, "number of functions to print by flat and cumulative time from the CPU profile after the run, 0 to skip")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [file or glob ...]\n       %s serve [flags]\n       %s worker [flags]\n       %s bench [flags] [file or glob ...]\n       %s report [flags]\n       %s compare [flags] [file]\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	version, found := findVersion(*versionName)
+	if !found {
+		log.Fatalf("unknown version %q", *versionName)
+	}
+
+	if *delimiter == `\t` {
+		*delimiter = "\t"
+	}
+	if len(*delimiter) != 1 {
+		log.Fatalf("the delimiter has to be a single byte, got %q", *delimiter)
+	}
+	options.Delimiter = (*delimiter)[0]
+	if options.Scale < 0 || options.Scale > maxScale {
+		log.Fatalf("the scale has to be between 0 and %d, got %d", maxScale, options.Scale)
+	}
+	outputOptions.Scale = options.Scale
+	outputUnit, err := parseUnit(*unit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outputOptions.Unit = outputUnit
+
+	if outputOptions.Sort, err = parseSortOrder(*sortOrder, *locale, *descending); err != nil {
+		log.Fatal(err)
+	}
+	if outputOptions.Rank, err = parseMetric(*rank); err != nil {
+		log.Fatal(err)
+	}
+	if outputOptions.Top < 0 || outputOptions.Bottom < 0 {
+		log.Fatal("-top and -bottom can't be negative")
+	}
+	if outputOptions.Top > 0 && outputOptions.Bottom > 0 {
+		log.Fatal("-top and -bottom can't be combined")
+	}
+
+	var filter CityFilter
+	if filter.Include, err = newCityMatcher(*includeFile, *includePrefix, *includeRegexp); err != nil {
+		log.Fatal(err)
+	}
+	if filter.Exclude, err = newCityMatcher(*excludeFile, *excludePrefix, *excludeRegexp); err != nil {
+		log.Fatal(err)
+	}
+	if !filter.Include.empty() || !filter.Exclude.empty() {
+		filter.apply(&options, &outputOptions, *snapshotPath != "" || *savePath != "")
+	}
+	if *snapshotPath != "" && *perFile {
+		log.Fatal("-per-file can't be combined with -snapshot")
+	}
+	if *windowSize != "" {
+		window := Window{Offset: *windowOffset, Layout: *timestampLayout}
+		if window.Size, err = parseWindowSize(*windowSize); err != nil {
+			log.Fatal(err)
+		}
+		if window.Location, err = time.LoadLocation(*windowZone); err != nil {
+			log.Fatal(err)
+		}
+		options.Window = &window
+	}
+	if options.Window != nil && (*snapshotPath != "" || *perFile || *followFile) {
+		log.Fatal("-window can't be combined with -snapshot, -per-file or -follow")
+	}
+	if *workerAddrs != "" && (*snapshotPath != "" || *perFile || *followFile || options.Window != nil) {
+		log.Fatal("-workers can't be combined with -snapshot, -per-file, -follow or -window")
+	}
+	if *mergeInputs && (*snapshotPath != "" || *perFile || *followFile || options.Window != nil || *workerAddrs != "") {
+		log.Fatal("-merge can't be combined with -snapshot, -per-file, -follow, -window or -workers")
+	}
+	if *savePath != "" && options.Window != nil {
+		log.Fatal("-save can't be combined with -window")
+	}
+	recorded, err := parseProfileKinds(*profileList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *blockRate < 1 || *mutexFraction < 1 {
+		log.Fatal("-block-rate and -mutex-fraction have to be at least 1")
+	}
+	if version.Name != "V11" && (options.Lenient || options.Delimiter != ';' || options.Scale != 1 || options.Window != nil || *workerAddrs != "") {
+		log.Fatalf("-lenient, -delimiter, -scale, -window and -workers are only supported by V11")
+	}
+
+	// Defaults to the measurements file generated in the inner 1brc directory
+	inputs := []string{measurementsPath}
+	if flag.NArg() > 0 {
+		inputs, err = expandInputs(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *followFile {
+		if len(inputs) != 1 || *snapshotPath != "" || *perFile {
+			log.Fatal("-follow takes a single file and can't be combined with -snapshot or -per-file")
+		}
+		follow(inputs[0], *followInterval, *followAddr)
+	}
+
 	fmt.Println("Running calculations")
 	fmt.Printf("Number of threads available: %d\n", runtime.NumCPU())
 	start := time.Now()
-	prof, err := os.Create("cpu.prof")
+	profiles, err := startProfiles(recorded, *profileDir, version.Name, *blockRate, *mutexFraction)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer prof.Close()
-	pprof.StartCPUProfile(prof)
-	defer pprof.StopCPUProfile()
-
-	// V1()
-	// V2()
-	// V3()
-	// V4()
-	// V5()
-	// V6()
-	// V7()
-	// V8()
-	// V9()
-	// V10()
-	// V11()
-	V12()
+	// Also called when a run without a partial result is interrupted, so only once
+	finishProfiles := sync.OnceFunc(func() {
+		if err := profiles.stop(); err != nil {
+			log.Print(err)
+		}
+		if *hotspots > 0 && slices.Contains(recorded, "cpu") {
+			spots, err := profileHotspots(profiles.path("cpu"))
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			fmt.Print(formatHotspots(spots, *hotspots))
+		}
+	})
+	defer finishProfiles()
+
+	// Ctrl-C stops reading at the end of the current lines and prints what was
+	// aggregated so far, a second Ctrl-C exits right away. Snapshots, distributed runs
+	// and merges have no partial result, they exit on the first Ctrl-C after writing the
+	// profiles and leave the snapshot as it was
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	partial := *snapshotPath == "" && *workerAddrs == "" && !*mergeInputs
+	go func() {
+		<-ctx.Done()
+		stop()
+		if !partial {
+			finishProfiles()
+			log.Fatal("interrupted, -snapshot, -workers and -merge runs have no partial result")
+		}
+	}()
+
+	if options.Window != nil {
+		windows, err := aggregateWindowFiles(ctx, inputs, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted, partial result of the lines read so far:")
+		}
+		fmt.Print(formatWindows(windows, options.Window, outputOptions))
+		fmt.Printf("Took %s to run\n", time.Since(start))
+		return
+	}
+
+	table := newTable(options.Scale)
+	values := table.Values
+	if *mergeInputs {
+		if table, err = mergeTableFiles(inputs); err != nil {
+			log.Fatal(err)
+		}
+		values = table.Values
+		outputOptions.Scale = table.Scale
+	} else if *workerAddrs != "" {
+		var stats Stats
+		values, stats, err = aggregateDistributed(inputs, strings.Split(*workerAddrs, ","), options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if stats.skipped() > 0 {
+			log.Print(stats.summary())
+		}
+	} else if *snapshotPath != "" {
+		values, err = aggregateIncremental(*snapshotPath, inputs, version, options.Scale)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var progress *Progress
+		stopProgress := func() {}
+		if *showProgress {
+			progress = newProgress(inputsSize(inputs))
+			options.Progress = progress
+			stopProgress = progress.report(os.Stderr, 500*time.Millisecond)
+		}
+		results, read, err := aggregateFilesContext(ctx, inputs, version, progress)
+		stopProgress()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *perFile {
+			fmt.Print(formatFileResults(inputs, results, outputOptions))
+		}
+		for _, result := range results {
+			mergeResult(values, result)
+		}
+		if ctx.Err() != nil {
+			fmt.Printf("Interrupted after reading %d bytes, partial result of %d rows:\n", read, values.rows())
+		}
+	}
+	fmt.Println(formatResult(values, outputOptions))
+
+	if *savePath != "" {
+		table.Values = values
+		if err := table.save(*savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	elapsed := time.Since(start)
 	fmt.Printf("Took %s to run\n", elapsed)
@@ -59,26 +332,39 @@ func main() {
 // bufio.(*Scanner).Scan 8seconds
 //
 // Mac Average time 2minute 25seconds
-func V1() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V1(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	minVals := make(map[string]float64)
 	meanVals := make(map[string]float64)
 	meanCount := make(map[string]int)
 	maxVals := make(map[string]float64)
 	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), ";")
+		line := scanner.Text()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(line) == 0 {
+			continue
+		}
+
+		parts := strings.Split(line, ";")
+		if len(parts) == 1 {
+			return nil, &LineError{Kind: errMissingDelimiter, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
+		if len(parts[0]) == 0 {
+			return nil, &LineError{Kind: errEmptyCity, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
 		key := parts[0]
+		// strconv.ParseFloat also accepts NaN, Inf, exponents and more digits, so the value
+		// is checked against the temperatures of the challenge first
+		if _, kind, ok := parseTemperature([]byte(parts[1]), 1); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
 		var64, err := strconv.ParseFloat(parts[1], 64)
 
 		if err != nil {
-			log.Fatal(err)
+			return nil, &LineError{Kind: errInvalidValue, Line: pos.Line, Offset: pos.Offset, Text: line, Err: err}
 		}
 
 		// Min eval
@@ -95,6 +381,7 @@ func V1() {
 		val, found = meanVals[key]
 		if !found {
 			meanVals[key] = var64
+			meanCount[key] = 1
 		} else {
 			meanVals[key] = val + var64
 			meanCount[key] = meanCount[key] + 1
@@ -111,30 +398,11 @@ func V1() {
 		}
 	}
 
-	keys := make([]string, len(minVals))
-	idx := 0
-	for key := range minVals {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := math.Round(minVals[key]*10) / 10
-		meanVal := math.Round(meanVals[key]/float64(meanCount[key])*10) / 10
-		maxVal := math.Round(maxVals[key]*10) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesV1Result(minVals, meanVals, meanCount, maxVals), nil
 }
 
 type Values struct {
@@ -154,28 +422,41 @@ type Values struct {
 // bufio(*Scanner).Text 7seconds
 //
 // Mac Average time 1minute 37seconds
-func V2() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V2(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*Values)
 	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), ";")
+		line := scanner.Text()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(line) == 0 {
+			continue
+		}
+
+		parts := strings.Split(line, ";")
+		if len(parts) == 1 {
+			return nil, &LineError{Kind: errMissingDelimiter, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
+		if len(parts[0]) == 0 {
+			return nil, &LineError{Kind: errEmptyCity, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
 		key := parts[0]
+		// strconv.ParseFloat also accepts NaN, Inf, exponents and more digits, so the value
+		// is checked against the temperatures of the challenge first
+		if _, kind, ok := parseTemperature([]byte(parts[1]), 1); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: line}
+		}
 		var64, err := strconv.ParseFloat(parts[1], 64)
 
 		if err != nil {
-			log.Fatal(err)
+			return nil, &LineError{Kind: errInvalidValue, Line: pos.Line, Offset: pos.Offset, Text: line, Err: err}
 		}
 
 		val, found := values[key]
 		if !found {
-			values[key] = &Values{Min: var64, Sum: var64, Max: var64}
+			values[key] = &Values{Min: var64, Sum: var64, Max: var64, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var64 {
@@ -193,30 +474,11 @@ func V2() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := math.Round(values[key].Min*10) / 10
-		meanVal := math.Round(values[key].Sum/float64(values[key].Count)*10) / 10
-		maxVal := math.Round(values[key].Max*10) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesResult(values), nil
 }
 
 // Identical to V2 but opts for string slicing instead of using strings.Split
@@ -229,29 +491,38 @@ func V2() {
 // strings.Index 5seconds
 //
 // Mac Average time 1minute 8seconds
-func V3() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V3(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*Values)
 	for scanner.Scan() {
 		valStr := scanner.Text()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(valStr) == 0 {
+			continue
+		}
+
 		idx := strings.Index(valStr, ";")
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: valStr}
+		}
 		key := valStr[:idx]
+		// strconv.ParseFloat also accepts NaN, Inf, exponents and more digits, so the value
+		// is checked against the temperatures of the challenge first
+		if _, kind, ok := parseTemperature([]byte(valStr[idx+1:]), 1); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: valStr}
+		}
 		var64, err := strconv.ParseFloat(valStr[idx+1:], 64)
 
 		if err != nil {
-			log.Fatal(err)
+			return nil, &LineError{Kind: errInvalidValue, Line: pos.Line, Offset: pos.Offset, Text: valStr, Err: err}
 		}
 
 		val, found := values[key]
 		if !found {
-			values[key] = &Values{Min: var64, Sum: var64, Max: var64}
+			values[key] = &Values{Min: var64, Sum: var64, Max: var64, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var64 {
@@ -269,30 +540,11 @@ func V3() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := math.Round(values[key].Min*10) / 10
-		meanVal := math.Round(values[key].Sum/float64(values[key].Count)*10) / 10
-		maxVal := math.Round(values[key].Max*10) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesResult(values), nil
 }
 
 // Mostly identical to V3 but using scanner.Bytes() instead of scanner.Text()
@@ -303,18 +555,19 @@ func V3() {
 // bufio.(*Scanner).Scan 7seconds
 //
 // Mac Average time 57seconds
-func V4() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V4(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*Values)
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(lineBytes) == 0 {
+			continue
+		}
+
 		idx := -1
 		for i, b := range lineBytes {
 			if b == ';' {
@@ -322,44 +575,23 @@ func V4() {
 				break
 			}
 		}
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
+		}
 
 		keyBytes := lineBytes[:idx]
 		valBytes := lineBytes[idx+1:]
 		key := string(keyBytes)
 
-		var sign float64 = 1.0
-		var intPart, fracPart int64
-		var decimalSeen bool
-		var numStart int
-
-		if valBytes[0] == '-' {
-			sign = -1.0
-			numStart = 1
-		} else {
-			numStart = 0
-		}
-
-		for i := numStart; i < len(valBytes); i++ {
-			if valBytes[i] == '.' {
-				decimalSeen = true
-				continue
-			}
-			digit := int64(valBytes[i] - '0')
-			if !decimalSeen {
-				intPart = intPart*10 + digit
-			} else {
-				fracPart = digit
-			}
-		}
-		var64 := sign * (float64(intPart) + float64(fracPart)/10.0)
-
-		if err != nil {
-			log.Fatal(err)
+		tenths, kind, ok := parseTemperature(valBytes, 1)
+		if !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
 		}
+		var64 := float64(tenths) / 10.0
 
 		val, found := values[key]
 		if !found {
-			values[key] = &Values{Min: var64, Sum: var64, Max: var64}
+			values[key] = &Values{Min: var64, Sum: var64, Max: var64, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var64 {
@@ -377,30 +609,11 @@ func V4() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := math.Round(values[key].Min*10) / 10
-		meanVal := math.Round(values[key].Sum/float64(values[key].Count)*10) / 10
-		maxVal := math.Round(values[key].Max*10) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesResult(values), nil
 }
 
 // Pretty much the save as V4 but sets the size of the values map to 1,000
@@ -411,18 +624,19 @@ func V4() {
 // bufio.(*Scanner).Scan 7seconds
 //
 // Mac Average time 55seconds
-func V5() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V5(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*Values, 1000)
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(lineBytes) == 0 {
+			continue
+		}
+
 		idx := -1
 		for i, b := range lineBytes {
 			if b == ';' {
@@ -430,44 +644,23 @@ func V5() {
 				break
 			}
 		}
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
+		}
 
 		keyBytes := lineBytes[:idx]
 		valBytes := lineBytes[idx+1:]
 		key := string(keyBytes)
 
-		var sign float64 = 1.0
-		var intPart, fracPart int64
-		var decimalSeen bool
-		var numStart int
-
-		if valBytes[0] == '-' {
-			sign = -1.0
-			numStart = 1
-		} else {
-			numStart = 0
-		}
-
-		for i := numStart; i < len(valBytes); i++ {
-			if valBytes[i] == '.' {
-				decimalSeen = true
-				continue
-			}
-			digit := int64(valBytes[i] - '0')
-			if !decimalSeen {
-				intPart = intPart*10 + digit
-			} else {
-				fracPart = digit
-			}
-		}
-		var64 := sign * (float64(intPart) + float64(fracPart)/10.0)
-
-		if err != nil {
-			log.Fatal(err)
+		tenths, kind, ok := parseTemperature(valBytes, 1)
+		if !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
 		}
+		var64 := float64(tenths) / 10.0
 
 		val, found := values[key]
 		if !found {
-			values[key] = &Values{Min: var64, Sum: var64, Max: var64}
+			values[key] = &Values{Min: var64, Sum: var64, Max: var64, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var64 {
@@ -485,33 +678,15 @@ func V5() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := math.Round(values[key].Min*10) / 10
-		meanVal := math.Round(values[key].Sum/float64(values[key].Count)*10) / 10
-		maxVal := math.Round(values[key].Max*10) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesResult(values), nil
 }
 
-// Store the values as int and do the final float calculation at the very end
+// Store the values as int and do the final float calculation at the very end. The values
+// are fixed point in tenths of a degree, or 10^-Scale degrees when a scale is configured
 type ValuesV2 struct {
 	Min   int32
 	Max   int32
@@ -528,18 +703,19 @@ type ValuesV2 struct {
 // bufio.(*Scanner).Scan 7seconds
 //
 // Mac Average time 54seconds
-func V6() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V6(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*ValuesV2, 1000)
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(lineBytes) == 0 {
+			continue
+		}
+
 		idx := -1
 		for i, b := range lineBytes {
 			if b == ';' {
@@ -547,44 +723,22 @@ func V6() {
 				break
 			}
 		}
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
+		}
 
 		keyBytes := lineBytes[:idx]
 		valBytes := lineBytes[idx+1:]
 		key := string(keyBytes)
 
-		var sign int32 = 1
-		var intPart, fracPart int32
-		var decimalSeen bool
-		var numStart int
-
-		if valBytes[0] == '-' {
-			sign = -1
-			numStart = 1
-		} else {
-			numStart = 0
-		}
-
-		for i := numStart; i < len(valBytes); i++ {
-			if valBytes[i] == '.' {
-				decimalSeen = true
-				continue
-			}
-			digit := int32(valBytes[i] - '0')
-			if !decimalSeen {
-				intPart = intPart*10 + digit
-			} else {
-				fracPart = digit
-			}
+		var32, kind, ok := parseTemperature(valBytes, 1)
+		if !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
 		}
-		var32 := sign * (intPart*10 + fracPart)
 		var64 := int64(var32)
 
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		if val, found := values[key]; !found {
-			values[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32}
+			values[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var32 {
@@ -602,30 +756,11 @@ func V6() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := float64(values[key].Min) / 10
-		meanVal := math.Round(float64(values[key].Sum)/float64(values[key].Count)*10) / 100
-		maxVal := float64(values[key].Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesV2Result(values), nil
 }
 
 // Identical to V6 but utilizing bytes.IndexByte to locate the semicolon instead
@@ -637,57 +772,36 @@ func V6() {
 // runtime.slicebytetostring 6seconds
 //
 // Mac Average time 54seconds
-func V7() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V7(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[string]*ValuesV2, 1000)
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(lineBytes) == 0 {
+			continue
+		}
+
 		idx := bytes.IndexByte(lineBytes, ';')
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
+		}
 
 		keyBytes := lineBytes[:idx]
 		valBytes := lineBytes[idx+1:]
 		key := string(keyBytes)
 
-		var sign int32 = 1
-		var intPart, fracPart int32
-		var decimalSeen bool
-		var numStart int
-
-		if valBytes[0] == '-' {
-			sign = -1
-			numStart = 1
-		} else {
-			numStart = 0
-		}
-
-		for i := numStart; i < len(valBytes); i++ {
-			if valBytes[i] == '.' {
-				decimalSeen = true
-				continue
-			}
-			digit := int32(valBytes[i] - '0')
-			if !decimalSeen {
-				intPart = intPart*10 + digit
-			} else {
-				fracPart = digit
-			}
+		var32, kind, ok := parseTemperature(valBytes, 1)
+		if !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
 		}
-		var32 := sign * (intPart*10 + fracPart)
 		var64 := int64(var32)
 
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		if val, found := values[key]; !found {
-			values[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32}
+			values[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var32 {
@@ -705,30 +819,11 @@ func V7() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := float64(values[key].Min) / 10
-		meanVal := math.Round(float64(values[key].Sum)/float64(values[key].Count)*10) / 100
-		maxVal := float64(values[key].Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesV2Result(values), nil
 }
 
 // Starts with the base of V7 but overrides the scanner Split() method to return a string
@@ -742,67 +837,73 @@ func V7() {
 // runtime.mcall 6seconds
 //
 // Mac Average time 13seconds
-func V8() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
+func V8(r io.Reader) (Result, error) {
 	// The number of workers to spin up to handle line chunk processing/calculations, mess
 	// around with the number of workers to view the impact
 	workers := 10
 
+	// A chunk of lines as a string along with the position of its first line, like the
+	// chunks of the pipeline
+	type textChunk struct {
+		text   string
+		offset int64
+		line   int64
+	}
+
 	var wg sync.WaitGroup
-	linesChan := make(chan string, 10000)
+	var failed atomic.Bool
+	linesChan := make(chan textChunk, 10000)
 	resultMaps := make([]map[string]*ValuesV2, workers)
+	workerErrs := make([]*LineError, workers)
+	workerPanics := make([]error, workers)
 
 	for idx := range workers {
 		wg.Add(1)
 		resultMap := make(map[string]*ValuesV2)
 		resultMaps[idx] = resultMap
-		go func(wg *sync.WaitGroup, input chan string, output map[string]*ValuesV2) {
-			for chunkStr := range input {
-				for lineStr := range strings.SplitSeq(chunkStr, "\n") {
+		go func(wg *sync.WaitGroup, input chan textChunk, output map[string]*ValuesV2, firstErr **LineError) {
+			defer wg.Done()
+			defer recoverWorker(input, &workerPanics[idx], &failed)
+			for chunk := range input {
+				offset, line := chunk.offset, chunk.line
+				for lineStr := range strings.SplitSeq(chunk.text, "\n") {
+					lineOffset, lineNumber := offset, line
+					offset += int64(len(lineStr)) + 1
+					line++
+
+					// Normalize CRLF line endings and skip blank lines, including the empty
+					// line after the trailing newline of the last chunk
+					lineStr = strings.TrimSuffix(lineStr, "\r")
+					if len(lineStr) == 0 {
+						continue
+					}
+
 					idx := strings.Index(lineStr, ";")
+					if kind, ok := delimiterKind(idx); !ok {
+						if *firstErr == nil {
+							*firstErr = &LineError{Kind: kind, Line: lineNumber, Offset: lineOffset, Text: lineStr}
+							failed.Store(true)
+						}
+						continue
+					}
 
 					keyBytes := lineStr[:idx]
 					valBytes := lineStr[idx+1:]
 					key := string(keyBytes)
 
-					var sign int32 = 1
-					var intPart, fracPart int32
-					var decimalSeen bool
-					var numStart int
-
-					if valBytes[0] == '-' {
-						sign = -1
-						numStart = 1
-					} else {
-						numStart = 0
-					}
-
-					for i := numStart; i < len(valBytes); i++ {
-						if valBytes[i] == '.' {
-							decimalSeen = true
-							continue
-						}
-						digit := int32(valBytes[i] - '0')
-						if !decimalSeen {
-							intPart = intPart*10 + digit
-						} else {
-							fracPart = digit
+					var32, kind, ok := parseTemperature([]byte(valBytes), 1)
+					if !ok {
+						// Keep the first malformed line to fail with once the workers are done
+						if *firstErr == nil {
+							*firstErr = &LineError{Kind: kind, Line: lineNumber, Offset: lineOffset, Text: lineStr}
+							failed.Store(true)
 						}
+						continue
 					}
-					var32 := sign * (intPart*10 + fracPart)
 					var64 := int64(var32)
 
-					if err != nil {
-						log.Fatal(err)
-					}
-
 					if val, found := output[key]; !found {
-						output[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32}
+						output[key] = &ValuesV2{Min: var32, Sum: var64, Max: var32, Count: 1}
 					} else {
 						// Min eval
 						if val.Min > var32 {
@@ -820,11 +921,10 @@ func V8() {
 					}
 				}
 			}
-			wg.Done()
-		}(&wg, linesChan, resultMap)
+		}(&wg, linesChan, resultMap, &workerErrs[idx])
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 
 	// Create chunks of 1000 lines instead of reading line by line, mess around with line
 	// chunks to view the impact
@@ -853,14 +953,39 @@ func V8() {
 		return 0, nil, nil
 	})
 
+	// Every chunk but the last holds exactly linesPerChunk lines, so the line number of
+	// each chunk is known up front without counting the newlines again. Reading stops
+	// after a malformed line, the chunks already queued are still aggregated so the
+	// earliest one is reported
 	values := make(map[string]*ValuesV2, 1000)
-	for scanner.Scan() {
-		linesChan <- scanner.Text()
+	var offset, line int64 = 0, 1
+	for !failed.Load() && scanner.Scan() {
+		chunkStr := scanner.Text()
+		linesChan <- textChunk{text: chunkStr, offset: offset, line: line}
+		offset += int64(len(chunkStr)) + 1
+		line += int64(linesPerChunk)
 	}
 
 	close(linesChan)
 	wg.Wait()
 
+	for _, err := range workerPanics {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Report the first malformed line in the input when multiple workers failed
+	var firstErr *LineError
+	for _, lineErr := range workerErrs {
+		if lineErr != nil && (firstErr == nil || lineErr.Offset < firstErr.Offset) {
+			firstErr = lineErr
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
 	for _, resultMap := range resultMaps {
 		for key, val := range resultMap {
 			if finalVal, found := values[key]; !found {
@@ -878,30 +1003,11 @@ func V8() {
 		}
 	}
 
-	keys := make([]string, len(values))
-	idx := 0
-	for key := range values {
-		keys[idx] = key
-		idx++
-	}
-	sort.Strings(keys)
-
-	output := "{"
-	for idx, key := range keys {
-		minVal := float64(values[key].Min) / 10
-		meanVal := math.Round(float64(values[key].Sum)/float64(values[key].Count)*10) / 100
-		maxVal := float64(values[key].Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, minVal, meanVal, maxVal)
-		if idx < len(keys)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return valuesV2Result(values), nil
 }
 
 // Mostly same as ValuesV2 but with the addition of the City field
@@ -923,20 +1029,24 @@ type ValuesV3 struct {
 // runtime.mapaccess2_fast64 12seconds
 //
 // Mac Average time 44seconds
-func V9() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+func V9(r io.Reader) (Result, error) {
+	scanner := bufio.NewScanner(r)
+	var pos linePosition
+	scanner.Split(pos.scanLines)
 
 	values := make(map[int64]*ValuesV3, 1000)
 	hasher := fnv.New64a()
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		// Skip blank lines, the scanner already drops the \r of CRLF line endings
+		if len(lineBytes) == 0 {
+			continue
+		}
+
 		idx := bytes.IndexByte(lineBytes, ';')
+		if kind, ok := delimiterKind(idx); !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
+		}
 
 		keyBytes := lineBytes[:idx]
 		valBytes := lineBytes[idx+1:]
@@ -945,39 +1055,14 @@ func V9() {
 		key := int64(hasher.Sum64())
 		hasher.Reset()
 
-		var sign int32 = 1
-		var intPart, fracPart int32
-		var decimalSeen bool
-		var numStart int
-
-		if valBytes[0] == '-' {
-			sign = -1
-			numStart = 1
-		} else {
-			numStart = 0
-		}
-
-		for i := numStart; i < len(valBytes); i++ {
-			if valBytes[i] == '.' {
-				decimalSeen = true
-				continue
-			}
-			digit := int32(valBytes[i] - '0')
-			if !decimalSeen {
-				intPart = intPart*10 + digit
-			} else {
-				fracPart = digit
-			}
+		var32, kind, ok := parseTemperature(valBytes, 1)
+		if !ok {
+			return nil, &LineError{Kind: kind, Line: pos.Line, Offset: pos.Offset, Text: string(lineBytes)}
 		}
-		var32 := sign * (intPart*10 + fracPart)
 		var64 := int64(var32)
 
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		if val, found := values[key]; !found {
-			values[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32}
+			values[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32, Count: 1}
 		} else {
 			// Min eval
 			if val.Min > var32 {
@@ -995,32 +1080,11 @@ func V9() {
 		}
 	}
 
-	sortedValues := make([]*ValuesV3, len(values))
-	idx := 0
-	for _, value := range values {
-		sortedValues[idx] = value
-		idx++
-	}
-	sort.Slice(sortedValues, func(i, j int) bool {
-		return sortedValues[i].City < sortedValues[j].City
-	})
-
-	output := "{"
-	for idx, value := range sortedValues {
-		minVal := float64(value.Min) / 10
-		meanVal := math.Round(float64(value.Sum)/float64(value.Count)*10) / 100
-		maxVal := float64(value.Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", value.City, minVal, meanVal, maxVal)
-		if idx < len(sortedValues)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return values, nil
 }
 
 // A combination of V8 and V9, spreading the work over various workers and using a int64
@@ -1033,30 +1097,48 @@ func V9() {
 // runtime.gcBgMarkWorker 5seconds
 //
 // Mac Average time 14seconds
-func V10() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
+func V10(r io.Reader) (Result, error) {
 	// The number of workers to spin up to handle line chunk processing/calculations, mess
 	// around with the number of workers to view the impact
 	workers := 10
 
 	var wg sync.WaitGroup
-	linesChan := make(chan []byte, 10000)
+	var failed atomic.Bool
+	linesChan := make(chan chunk, 10000)
 	resultMaps := make([]map[int64]*ValuesV3, workers)
+	workerErrs := make([]*LineError, workers)
+	workerPanics := make([]error, workers)
 
 	for idx := range workers {
 		wg.Add(1)
 		resultMap := make(map[int64]*ValuesV3)
 		resultMaps[idx] = resultMap
-		go func(wg *sync.WaitGroup, input chan []byte, output map[int64]*ValuesV3) {
+		go func(wg *sync.WaitGroup, input chan chunk, output map[int64]*ValuesV3, firstErr **LineError) {
+			defer wg.Done()
+			defer recoverWorker(input, &workerPanics[idx], &failed)
 			hasher := fnv.New64a()
-			for chunkBytes := range input {
-				for lineBytes := range bytes.SplitSeq(chunkBytes, []byte("\n")) {
+			for chunk := range input {
+				offset, line := chunk.offset, chunk.line
+				for lineBytes := range bytes.SplitSeq(chunk.data, []byte("\n")) {
+					lineOffset, lineNumber := offset, line
+					offset += int64(len(lineBytes)) + 1
+					line++
+
+					// Normalize CRLF line endings and skip blank lines, including the empty
+					// line after the trailing newline of the last chunk
+					lineBytes = bytes.TrimSuffix(lineBytes, []byte("\r"))
+					if len(lineBytes) == 0 {
+						continue
+					}
+
 					idx := bytes.IndexByte(lineBytes, ';')
+					if kind, ok := delimiterKind(idx); !ok {
+						if *firstErr == nil {
+							*firstErr = &LineError{Kind: kind, Line: lineNumber, Offset: lineOffset, Text: string(lineBytes)}
+							failed.Store(true)
+						}
+						continue
+					}
 
 					keyBytes := lineBytes[:idx]
 					valBytes := lineBytes[idx+1:]
@@ -1065,39 +1147,19 @@ func V10() {
 					key := int64(hasher.Sum64())
 					hasher.Reset()
 
-					var sign int32 = 1
-					var intPart, fracPart int32
-					var decimalSeen bool
-					var numStart int
-
-					if valBytes[0] == '-' {
-						sign = -1
-						numStart = 1
-					} else {
-						numStart = 0
-					}
-
-					for i := numStart; i < len(valBytes); i++ {
-						if valBytes[i] == '.' {
-							decimalSeen = true
-							continue
-						}
-						digit := int32(valBytes[i] - '0')
-						if !decimalSeen {
-							intPart = intPart*10 + digit
-						} else {
-							fracPart = digit
+					var32, kind, ok := parseTemperature(valBytes, 1)
+					if !ok {
+						// Keep the first malformed line to fail with once the workers are done
+						if *firstErr == nil {
+							*firstErr = &LineError{Kind: kind, Line: lineNumber, Offset: lineOffset, Text: string(lineBytes)}
+							failed.Store(true)
 						}
+						continue
 					}
-					var32 := sign * (intPart*10 + fracPart)
 					var64 := int64(var32)
 
-					if err != nil {
-						log.Fatal(err)
-					}
-
 					if val, found := output[key]; !found {
-						output[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32}
+						output[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32, Count: 1}
 					} else {
 						// Min eval
 						if val.Min > var32 {
@@ -1115,11 +1177,10 @@ func V10() {
 					}
 				}
 			}
-			wg.Done()
-		}(&wg, linesChan, resultMap)
+		}(&wg, linesChan, resultMap, &workerErrs[idx])
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 
 	// Create chunks of 1000 lines instead of reading line by line, mess around with line
 	// chunks to view the impact
@@ -1148,64 +1209,55 @@ func V10() {
 		return 0, nil, nil
 	})
 
+	// Every chunk but the last holds exactly linesPerChunk lines, so the line number of
+	// each chunk is known up front without counting the newlines again. Reading stops
+	// after a malformed line, the chunks already queued are still aggregated so the
+	// earliest one is reported
 	values := make(map[int64]*ValuesV3, 1000)
-	for scanner.Scan() {
+	var offset, line int64 = 0, 1
+	for !failed.Load() && scanner.Scan() {
 		chunkBytes := scanner.Bytes()
 		chunkCopy := make([]byte, len(chunkBytes))
 		copy(chunkCopy, chunkBytes)
-		linesChan <- chunkCopy
+		linesChan <- chunk{data: chunkCopy, offset: offset, line: line}
+		offset += int64(len(chunkBytes)) + 1
+		line += int64(linesPerChunk)
 	}
 
 	close(linesChan)
 	wg.Wait()
 
-	for _, resultMap := range resultMaps {
-		for key, val := range resultMap {
-			if finalVal, found := values[key]; !found {
-				values[key] = val
-			} else {
-				if finalVal.Min > val.Min {
-					finalVal.Min = val.Min
-				}
-				finalVal.Sum += val.Sum
-				finalVal.Count += val.Count
-				if finalVal.Max < val.Max {
-					finalVal.Max = val.Max
-				}
-			}
+	for _, err := range workerPanics {
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	sortedValues := make([]*ValuesV3, len(values))
-	idx := 0
-	for _, value := range values {
-		sortedValues[idx] = value
-		idx++
+	// Report the first malformed line in the input when multiple workers failed
+	var firstErr *LineError
+	for _, lineErr := range workerErrs {
+		if lineErr != nil && (firstErr == nil || lineErr.Offset < firstErr.Offset) {
+			firstErr = lineErr
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
 	}
-	sort.Slice(sortedValues, func(i, j int) bool {
-		return sortedValues[i].City < sortedValues[j].City
-	})
 
-	output := "{"
-	for idx, value := range sortedValues {
-		minVal := float64(value.Min) / 10
-		meanVal := math.Round(float64(value.Sum)/float64(value.Count)*10) / 100
-		maxVal := float64(value.Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", value.City, minVal, meanVal, maxVal)
-		if idx < len(sortedValues)-1 {
-			output += ", "
-		}
+	for _, resultMap := range resultMaps {
+		mergeResult(values, resultMap)
 	}
-	output += "}"
-	fmt.Println(output)
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return values, nil
 }
 
 // Identical to V10 but updating workers to be 1 less than the number of threads on the CPU
-// and increasing scanner buffer size
+// and increasing scanner buffer size. The pipeline itself lives in aggregate so the
+// command line options can build on top of it
 //
 // Average time 10seconds
 // main.V11.func1.SplitSeq.splitSeq.1 34seconds
@@ -1214,189 +1266,23 @@ func V10() {
 // runtime.mcall 3seconds
 //
 // Mac Average time 14seconds
-func V11() {
-	file, err := os.Open("../1brc/measurements.txt")
+func V11(r io.Reader) (Result, error) {
+	values, stats, err := aggregate(r, options)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer file.Close()
 
-	// The number of workers to spin up to handle line chunk processing/calculations, mess
-	// around with the number of workers to view the impact
-	workers := runtime.NumCPU() - 1
-
-	var wg sync.WaitGroup
-	linesChan := make(chan []byte, 10000)
-	resultMaps := make([]map[int64]*ValuesV3, workers)
-
-	for idx := range workers {
-		wg.Add(1)
-		resultMap := make(map[int64]*ValuesV3)
-		resultMaps[idx] = resultMap
-		go func(wg *sync.WaitGroup, input chan []byte, output map[int64]*ValuesV3) {
-			hasher := fnv.New64a()
-			for chunkBytes := range input {
-				for lineBytes := range bytes.SplitSeq(chunkBytes, []byte("\n")) {
-					idx := bytes.IndexByte(lineBytes, ';')
-
-					keyBytes := lineBytes[:idx]
-					valBytes := lineBytes[idx+1:]
-
-					hasher.Write(keyBytes)
-					key := int64(hasher.Sum64())
-					hasher.Reset()
-
-					var sign int32 = 1
-					var intPart, fracPart int32
-					var decimalSeen bool
-					var numStart int
-
-					if valBytes[0] == '-' {
-						sign = -1
-						numStart = 1
-					} else {
-						numStart = 0
-					}
-
-					for i := numStart; i < len(valBytes); i++ {
-						if valBytes[i] == '.' {
-							decimalSeen = true
-							continue
-						}
-						digit := int32(valBytes[i] - '0')
-						if !decimalSeen {
-							intPart = intPart*10 + digit
-						} else {
-							fracPart = digit
-						}
-					}
-					var32 := sign * (intPart*10 + fracPart)
-					var64 := int64(var32)
-
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					if val, found := output[key]; !found {
-						output[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32}
-					} else {
-						// Min eval
-						if val.Min > var32 {
-							val.Min = var32
-						}
-
-						// Mean eval
-						val.Sum += var64
-						val.Count++
-
-						// Max eval
-						if val.Max < var32 {
-							val.Max = var32
-						}
-					}
-				}
-			}
-			wg.Done()
-		}(&wg, linesChan, resultMap)
-	}
-
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	// Create chunks of 1000 lines instead of reading line by line, mess around with line
-	// chunks to view the impact
-	linesPerChunk := 1000
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-
-		newlineCount := 0
-		lastNewlineIndex := -1
-		for i, b := range data {
-			if b == '\n' {
-				newlineCount++
-				lastNewlineIndex = i
-			}
-			if newlineCount >= linesPerChunk {
-				return lastNewlineIndex + 1, data[:lastNewlineIndex], nil
-			}
-		}
-
-		if atEOF {
-			return len(data), data, nil
-		}
-
-		return 0, nil, nil
-	})
-
-	values := make(map[int64]*ValuesV3, 1000)
-	for scanner.Scan() {
-		chunkBytes := scanner.Bytes()
-		chunkCopy := make([]byte, len(chunkBytes))
-		copy(chunkCopy, chunkBytes)
-		linesChan <- chunkCopy
+	if stats.skipped() > 0 {
+		log.Print(stats.summary())
 	}
 
-	close(linesChan)
-	wg.Wait()
-
-	for _, resultMap := range resultMaps {
-		for key, val := range resultMap {
-			if finalVal, found := values[key]; !found {
-				values[key] = val
-			} else {
-				if finalVal.Min > val.Min {
-					finalVal.Min = val.Min
-				}
-				finalVal.Sum += val.Sum
-				finalVal.Count += val.Count
-				if finalVal.Max < val.Max {
-					finalVal.Max = val.Max
-				}
-			}
-		}
-	}
-
-	sortedValues := make([]*ValuesV3, len(values))
-	idx := 0
-	for _, value := range values {
-		sortedValues[idx] = value
-		idx++
-	}
-	sort.Slice(sortedValues, func(i, j int) bool {
-		return sortedValues[i].City < sortedValues[j].City
-	})
-
-	output := "{"
-	for idx, value := range sortedValues {
-		minVal := float64(value.Min) / 10
-		meanVal := math.Round(float64(value.Sum)/float64(value.Count)*10) / 100
-		maxVal := float64(value.Max) / 10
-		output += fmt.Sprintf("%s=%.1f/%.1f/%.1f", value.City, minVal, meanVal, maxVal)
-		if idx < len(sortedValues)-1 {
-			output += ", "
-		}
-	}
-	output += "}"
-	fmt.Println(output)
-
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
+	return values, nil
 }
 
 // WIP - Reading file using file.Read instead of tracking the buffer ourselves.
 // Think an approach worth trying is creating buffers per worker and reading into
 // those buffers that way we don't need to copy the buffer content for safe reading
-func V12() {
-	file, err := os.Open("../1brc/measurements.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
+func V12(r io.Reader) error {
 	// The number of workers to spin up to handle line chunk processing/calculations, mess
 	// around with the number of workers to view the impact
 	workers := runtime.NumCPU() - 1
@@ -1411,9 +1297,9 @@ func V12() {
 
 	idx := 0
 	for {
-		contentSize, err := file.Read(bufs[idx])
+		contentSize, err := r.Read(bufs[idx])
 		if err != nil && err != io.EOF {
-			log.Fatal(err)
+			return err
 		}
 
 		if contentSize == 0 {
@@ -1422,4 +1308,5 @@ func V12() {
 
 		idx = (idx + 1) % workers
 	}
+	return nil
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// Measurements with Unix line endings and a trailing newline, the layout every version
+// was written against
+const unixMeasurements = "Hamburg;12.0\nBulawayo;8.9\nPalembang;38.8\nHamburg;34.2\nBulawayo;-3.5\nHamburg;-1.0\n"
+
+// Generate enough lines to span multiple chunks of the chunked versions
+func manyMeasurements() string {
+	var builder strings.Builder
+	cities := []string{"Hamburg", "Bulawayo", "Palembang"}
+	for i := range 2500 {
+		fmt.Fprintf(&builder, "%s;%d.%d\n", cities[i%len(cities)], i%90-45, i%10)
+	}
+	return builder.String()
+}
+
+// Every version has to produce the same output for the input as for its Unix
+// counterpart
+func testLineEndings(t *testing.T, unix string, input string) {
+	t.Helper()
+	for _, version := range versions {
+		t.Run(version.Name, func(t *testing.T) {
+			wantResult, err := version.Run(strings.NewReader(unix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotResult, err := version.Run(strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := formatResult(gotResult, outputOptions), formatResult(wantResult, outputOptions); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnixLineEndings(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version.Name, func(t *testing.T) {
+			result, err := version.Run(strings.NewReader(unixMeasurements))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hamburg, found := result[cityKey([]byte("Hamburg"))]
+			if !found {
+				t.Fatal("missing Hamburg")
+			}
+			if hamburg.Min != -10 || hamburg.Max != 342 || hamburg.Sum != 452 {
+				t.Errorf("got Hamburg min %d max %d sum %d", hamburg.Min, hamburg.Max, hamburg.Sum)
+			}
+			if len(result) != 3 {
+				t.Errorf("got %d cities, want 3", len(result))
+			}
+		})
+	}
+}
+
+func TestCRLFLineEndings(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		testLineEndings(t, unixMeasurements, strings.ReplaceAll(unixMeasurements, "\n", "\r\n"))
+	})
+	t.Run("chunks", func(t *testing.T) {
+		many := manyMeasurements()
+		testLineEndings(t, many, strings.ReplaceAll(many, "\n", "\r\n"))
+	})
+}
+
+func TestMissingTrailingNewline(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		testLineEndings(t, unixMeasurements, strings.TrimSuffix(unixMeasurements, "\n"))
+	})
+	t.Run("chunks", func(t *testing.T) {
+		many := manyMeasurements()
+		testLineEndings(t, many, strings.TrimSuffix(many, "\n"))
+	})
+	t.Run("crlf", func(t *testing.T) {
+		crlf := strings.ReplaceAll(unixMeasurements, "\n", "\r\n")
+		testLineEndings(t, unixMeasurements, strings.TrimSuffix(crlf, "\r\n"))
+	})
+}
+
+func TestBlankLines(t *testing.T) {
+	t.Run("between", func(t *testing.T) {
+		testLineEndings(t, unixMeasurements, strings.ReplaceAll(unixMeasurements, "\n", "\n\n"))
+	})
+	t.Run("leading", func(t *testing.T) {
+		testLineEndings(t, unixMeasurements, "\n\n"+unixMeasurements)
+	})
+	t.Run("trailing crlf", func(t *testing.T) {
+		testLineEndings(t, unixMeasurements, unixMeasurements+"\r\n\r\n")
+	})
+	t.Run("chunks", func(t *testing.T) {
+		many := manyMeasurements()
+		testLineEndings(t, many, strings.ReplaceAll(many, "0\n", "0\n\n"))
+	})
+}
+
+// Lines without a city or delimiter fail every version with their position instead of
+// panicking, also on the worker goroutines of the chunked versions
+func TestDelimiterErrors(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		kind  lineErrorKind
+	}{
+		{"Hamburg;12.0\nBulawayo 8.9\nPalembang;38.8\n", errMissingDelimiter},
+		{"Hamburg;12.0\n;8.9\nPalembang;38.8\n", errEmptyCity},
+	} {
+		for _, version := range versions {
+			_, err := version.Run(strings.NewReader(test.input))
+			var lineErr *LineError
+			if !errors.As(err, &lineErr) || lineErr.Kind != test.kind || lineErr.Line != 2 || lineErr.Offset != 13 {
+				t.Errorf("%s: got error %v, want %s on line 2 at offset 13", version.Name, err, test.kind)
+			}
+		}
+	}
+}
+
+// Malformed values come back as errors with their position instead of exiting, wrapped
+// in the name of the input by aggregateInputs
+func TestVersionErrors(t *testing.T) {
+	many := manyMeasurements()
+	for _, test := range []struct {
+		name   string
+		input  string
+		line   int64
+		offset int64
+	}{
+		{"crlf", "Hamburg;12.0\r\nBulawayo;8.x\r\n", 2, 14},
+		// Past the first chunks of the chunked versions, which have to report the first
+		// of the malformed lines their workers ran into
+		{"chunks", many + "Bulawayo;8.x\n" + many + "Hamburg;1.x\n" + many, 2501, int64(len(many))},
+	} {
+		for _, version := range versions {
+			_, err := aggregateInputs([]string{test.name + ".txt"}, func(idx int) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(test.input)), nil
+			}, version)
+
+			var lineErr *LineError
+			if !errors.As(err, &lineErr) || lineErr.Line != test.line || lineErr.Offset != test.offset || !strings.HasPrefix(err.Error(), test.name+".txt: ") {
+				t.Errorf("%s: got error %v, want line %d at offset %d of %s.txt", version.Name, err, test.line, test.offset, test.name)
+			}
+		}
+	}
+
+	_, err := aggregateFiles([]string{"missing.txt"}, versions[0])
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want a missing file", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Statistic of a city the finalized values can be ranked by
+type Metric int
+
+const (
+	MetricMin Metric = iota
+	MetricMean
+	MetricMax
+	MetricRange
+)
+
+func parseMetric(name string) (Metric, error) {
+	switch strings.ToLower(name) {
+	case "min":
+		return MetricMin, nil
+	case "mean":
+		return MetricMean, nil
+	case "max":
+		return MetricMax, nil
+	case "range":
+		return MetricRange, nil
+	}
+	return MetricMin, fmt.Errorf("unknown metric %q, expected min, mean, max or range", name)
+}
+
+// Value of the metric in the fixed point units of the run, converting to a different
+// unit keeps the order so the ranking is always done on the Celsius values
+func (metric Metric) value(value *ValuesV3) float64 {
+	switch metric {
+	case MetricMin:
+		return float64(value.Min)
+	case MetricMean:
+		return float64(value.Sum) / float64(value.Count)
+	case MetricMax:
+		return float64(value.Max)
+	}
+	return float64(value.Max) - float64(value.Min)
+}
+
+// Rank the sorted values by the metric and keep the first k, the highest values first
+// for the top or the lowest values first for the bottom. Cities with the same value
+// stay in the order they were sorted in
+func rankValues(sortedValues []*ValuesV3, metric Metric, k int, top bool) []*ValuesV3 {
+	sort.SliceStable(sortedValues, func(i, j int) bool {
+		if top {
+			return metric.value(sortedValues[i]) > metric.value(sortedValues[j])
+		}
+		return metric.value(sortedValues[i]) < metric.value(sortedValues[j])
+	})
+	return sortedValues[:min(k, len(sortedValues))]
+}
+
+// What the cities in the output are ordered by
+type SortKey int
+
+const (
+	// Byte order of the city names, the order the challenge expects
+	SortByte SortKey = iota
+	// Unicode code point order, the same as byte order for valid UTF-8 and only
+	// different for names with invalid sequences
+	SortCodePoint
+	// Collation rules of a locale, placing accented names next to their base letters
+	SortLocale
+	// One of the statistics of the cities
+	SortMetric
+)
+
+// Order of the cities in the output, the zero value is ascending byte order
+type SortOrder struct {
+	Key        SortKey
+	Metric     Metric
+	Locale     language.Tag
+	Descending bool
+}
+
+// Parse the sort flag, either byte, codepoint, locale or one of the metrics
+func parseSortOrder(name string, locale string, descending bool) (SortOrder, error) {
+	order := SortOrder{Descending: descending}
+	switch strings.ToLower(name) {
+	case "byte":
+		order.Key = SortByte
+	case "codepoint":
+		order.Key = SortCodePoint
+	case "locale":
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return SortOrder{}, fmt.Errorf("invalid locale %q: %w", locale, err)
+		}
+		order.Key = SortLocale
+		order.Locale = tag
+	default:
+		metric, err := parseMetric(name)
+		if err != nil {
+			return SortOrder{}, fmt.Errorf("unknown sort order %q, expected byte, codepoint, locale, min, mean, max or range", name)
+		}
+		order.Key = SortMetric
+		order.Metric = metric
+	}
+	return order, nil
+}
+
+// Compare city names by their code points, decoding invalid sequences as the
+// replacement character
+func compareCodePoints(a string, b string) int {
+	for len(a) > 0 && len(b) > 0 {
+		runeA, sizeA := utf8.DecodeRuneInString(a)
+		runeB, sizeB := utf8.DecodeRuneInString(b)
+		if runeA != runeB {
+			return int(runeA) - int(runeB)
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) - len(b)
+}
+
+// Sort the values in the order, ties are broken by the byte order of the city names
+func sortValues(sortedValues []*ValuesV3, order SortOrder) {
+	var compare func(a *ValuesV3, b *ValuesV3) int
+	switch order.Key {
+	case SortCodePoint:
+		compare = func(a *ValuesV3, b *ValuesV3) int {
+			return compareCodePoints(a.City, b.City)
+		}
+	case SortLocale:
+		// Collators aren't safe for concurrent use, so every sort gets its own
+		collator := collate.New(order.Locale)
+		compare = func(a *ValuesV3, b *ValuesV3) int {
+			return collator.CompareString(a.City, b.City)
+		}
+	case SortMetric:
+		compare = func(a *ValuesV3, b *ValuesV3) int {
+			valA, valB := order.Metric.value(a), order.Metric.value(b)
+			switch {
+			case valA < valB:
+				return -1
+			case valA > valB:
+				return 1
+			}
+			return 0
+		}
+	default:
+		compare = func(a *ValuesV3, b *ValuesV3) int {
+			return strings.Compare(a.City, b.City)
+		}
+	}
+
+	sort.Slice(sortedValues, func(i, j int) bool {
+		cmp := compare(sortedValues[i], sortedValues[j])
+		if order.Descending {
+			cmp = -cmp
+		}
+		if cmp == 0 {
+			return sortedValues[i].City < sortedValues[j].City
+		}
+		return cmp < 0
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// An optional minus sign, digits and an optional decimal point followed by digits, the
+// layout parseTemperature accepts before the limits on the digits and the range
+var temperaturePattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Parse the temperature with strconv.ParseFloat, the reference parseTemperature is
+// checked against
+func referenceTemperature(text string, scale int) (int32, bool) {
+	if !temperaturePattern.MatchString(text) {
+		return 0, false
+	}
+	if _, frac, found := strings.Cut(text, "."); found && len(frac) > scale {
+		return 0, false
+	}
+	val, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.Abs(val) >= 100 {
+		return 0, false
+	}
+	return int32(math.Round(val * math.Pow10(scale))), true
+}
+
+func TestParseTemperature(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		scale int
+		want  int32
+		ok    bool
+	}{
+		{"12.3", 1, 123, true},
+		{"-12.3", 1, -123, true},
+		{"0.0", 1, 0, true},
+		{"-0.0", 1, 0, true},
+		{"99.9", 1, 999, true},
+		{"-99.9", 1, -999, true},
+		{"8", 1, 80, true},
+		{"08.5", 1, 85, true},
+		{"12.05", 2, 1205, true},
+		{"12", 0, 12, true},
+		{"99.9999", 4, 999999, true},
+		{"100.0", 1, 0, false},
+		{"-100.0", 1, 0, false},
+		{"100", 0, 0, false},
+		{"99999999999999999999.9", 1, 0, false},
+		{"700000", 4, 0, false},
+		{"", 1, 0, false},
+		{"-", 1, 0, false},
+		{"--1.0", 1, 0, false},
+		{"1..0", 1, 0, false},
+		{"1.0.0", 1, 0, false},
+		{"1.", 1, 0, false},
+		{".5", 1, 0, false},
+		{"+1.0", 1, 0, false},
+		{"1.05", 1, 0, false},
+		{"1.0", 0, 0, false},
+		{"1e1", 1, 0, false},
+		{" 1.0", 1, 0, false},
+		{"1.0\r", 1, 0, false},
+	} {
+		got, _, ok := parseTemperature([]byte(test.text), test.scale)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseTemperature(%q, %d) = %d, %t, want %d, %t", test.text, test.scale, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func FuzzParseTemperature(f *testing.F) {
+	for _, text := range []string{"12.3", "-99.9", "0", "100.0", "--1.0", "1..0", "", "1.", "-.5", "12.3456"} {
+		f.Add(text, uint8(1))
+	}
+	f.Add("12.05", uint8(2))
+	f.Add("7", uint8(0))
+
+	f.Fuzz(func(t *testing.T, text string, scale uint8) {
+		scale %= maxScale + 1
+		got, kind, ok := parseTemperature([]byte(text), int(scale))
+		want, wantOK := referenceTemperature(text, int(scale))
+		if ok != wantOK || got != want {
+			t.Fatalf("parseTemperature(%q, %d) = %d, %t, want %d, %t", text, scale, got, ok, want, wantOK)
+		}
+		if !ok && kind != errInvalidValue && !(kind == errEmptyValue && text == "") {
+			t.Fatalf("parseTemperature(%q, %d) rejected it as %s", text, scale, kind)
+		}
+	})
+}
+
+// Every version fails on the first malformed value instead of aggregating garbage, the
+// strconv.ParseFloat of the first versions included
+func TestVersionsRejectInvalidValues(t *testing.T) {
+	for _, value := range []string{"100.0", "--1.0", "1..0", "1.x", "NaN", "Inf", "1e3", "+1.0", "1.25"} {
+		input := "Hamburg;12.0\nBulawayo;" + value + "\nPalembang;38.8\n"
+		for _, version := range versions {
+			if _, err := version.Run(strings.NewReader(input)); err == nil {
+				t.Errorf("%s: got no error for %q", version.Name, value)
+			}
+		}
+	}
+}
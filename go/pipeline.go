@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The most fractional digits supported, keeping the fixed point values within int32
+const maxScale = 4
+
+// Options for the chunked pipeline behind V11, set from the command line flags
+type Options struct {
+	// Skip and count malformed lines instead of failing on the first one
+	Lenient bool
+	// Separator between the city and the temperature
+	Delimiter byte
+	// Number of fractional digits of the temperatures, the values are tracked as
+	// integers in units of 10^-Scale degrees
+	Scale int
+	// Cities to aggregate, when made up of exact names the workers skip the other
+	// cities before hashing them
+	Filter *CityFilter
+	// Time buckets of the leading timestamp column, lines have no timestamp when nil
+	Window *Window
+	// Counters the workers add their rows to, not tracked when nil
+	Progress *Progress
+}
+
+// The layout of the challenge, semicolon separated temperatures with one fractional digit
+func defaultOptions() Options {
+	return Options{Delimiter: ';', Scale: 1}
+}
+
+// Options used when running V11, set from the command line flags in main
+var options = defaultOptions()
+
+// The kinds of malformed lines the pipeline detects
+type lineErrorKind int
+
+const (
+	errMissingDelimiter lineErrorKind = iota
+	errEmptyCity
+	errEmptyValue
+	errInvalidValue
+	errInvalidTimestamp
+	lineErrorKinds
+)
+
+func (kind lineErrorKind) String() string {
+	switch kind {
+	case errMissingDelimiter:
+		return "missing delimiter"
+	case errEmptyCity:
+		return "empty city"
+	case errEmptyValue:
+		return "empty value"
+	case errInvalidValue:
+		return "invalid value"
+	case errInvalidTimestamp:
+		return "invalid timestamp"
+	}
+	return "unknown"
+}
+
+// A malformed line along with its position in the input
+type LineError struct {
+	Kind   lineErrorKind
+	Line   int64
+	Offset int64
+	Text   string
+	// The parse error behind the kind, when there is one
+	Err error
+}
+
+func (e *LineError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("line %d (offset %d): %s: %q: %v", e.Line, e.Offset, e.Kind, e.Text, e.Err)
+	}
+	return fmt.Sprintf("line %d (offset %d): %s: %q", e.Line, e.Offset, e.Kind, e.Text)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Bookkeeping of a pipeline run
+type Stats struct {
+	Rows    int64
+	Skipped [lineErrorKinds]int64
+}
+
+func (s *Stats) add(other Stats) {
+	s.Rows += other.Rows
+	for kind, count := range other.Skipped {
+		s.Skipped[kind] += count
+	}
+}
+
+// Total number of malformed lines skipped
+func (s Stats) skipped() int64 {
+	var total int64
+	for _, count := range s.Skipped {
+		total += count
+	}
+	return total
+}
+
+// Summary of the malformed lines skipped in lenient mode
+func (s Stats) summary() string {
+	parts := make([]string, 0, len(s.Skipped))
+	for kind, count := range s.Skipped {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, lineErrorKind(kind)))
+		}
+	}
+	return fmt.Sprintf("skipped %d malformed lines of %d: %s", s.skipped(), s.Rows+s.skipped(), strings.Join(parts, ", "))
+}
+
+// A chunk of lines handed to the workers along with the position of its first line
+// so malformed lines can be reported
+type chunk struct {
+	data   []byte
+	offset int64
+	line   int64
+}
+
+// Check the index of the delimiter in a line, which is missing when negative and has
+// to follow a non-empty city
+func delimiterKind(idx int) (lineErrorKind, bool) {
+	switch {
+	case idx < 0:
+		return errMissingDelimiter, false
+	case idx == 0:
+		return errEmptyCity, false
+	}
+	return 0, true
+}
+
+// Exclusive bound of the parsed temperatures per scale, the challenge limits them to
+// -99.9 through 99.9 degrees
+var temperatureLimits = [maxScale + 1]int32{100, 1000, 10000, 100000, 1000000}
+
+// Parse a fixed point temperature into an integer with the given number of fractional
+// digits, tenths of a degree for the default scale of 1. Only an optional minus sign,
+// digits and a single decimal point followed by at most scale digits are accepted,
+// values with fewer fractional digits are padded to the scale. Values of 100 degrees
+// and more either way are out of range
+func parseTemperature(valBytes []byte, scale int) (int32, lineErrorKind, bool) {
+	if len(valBytes) == 0 {
+		return 0, errEmptyValue, false
+	}
+
+	var sign int32 = 1
+	var value int32
+	var decimalSeen bool
+	var fracDigits int
+	var numStart int
+
+	if valBytes[0] == '-' {
+		sign = -1
+		numStart = 1
+	}
+
+	if numStart == len(valBytes) || valBytes[numStart] < '0' || valBytes[numStart] > '9' {
+		return 0, errInvalidValue, false
+	}
+
+	for i := numStart; i < len(valBytes); i++ {
+		if valBytes[i] == '.' {
+			// At least one digit has to follow the decimal point
+			if decimalSeen || i == len(valBytes)-1 {
+				return 0, errInvalidValue, false
+			}
+			decimalSeen = true
+			continue
+		}
+		if valBytes[i] < '0' || valBytes[i] > '9' {
+			return 0, errInvalidValue, false
+		}
+		if decimalSeen {
+			fracDigits++
+			if fracDigits > scale {
+				return 0, errInvalidValue, false
+			}
+		}
+		value = value*10 + int32(valBytes[i]-'0')
+		// Checked as the digits come in so long values can't overflow
+		if value >= temperatureLimits[scale] {
+			return 0, errInvalidValue, false
+		}
+	}
+
+	for ; fracDigits < scale; fracDigits++ {
+		value *= 10
+		if value >= temperatureLimits[scale] {
+			return 0, errInvalidValue, false
+		}
+	}
+	return sign * value, 0, true
+}
+
+// Aggregates chunks of lines into its map, the work done by every pipeline worker and
+// by the follow mode for the lines appended to a file
+type chunkAggregator struct {
+	output map[int64]*ValuesV3
+	// Values per time bucket instead of output when the options have a window
+	windows WindowResult
+	stats   Stats
+	hasher  hash.Hash64
+	opts    Options
+	// Exact city filter the lines are checked against before hashing them
+	filterNames   map[string]bool
+	filterInclude bool
+	filterExact   bool
+}
+
+func newChunkAggregator(output map[int64]*ValuesV3, opts Options) *chunkAggregator {
+	aggregator := &chunkAggregator{output: output, hasher: fnv.New64a(), opts: opts}
+	if opts.Window != nil {
+		aggregator.windows = make(WindowResult)
+	}
+	aggregator.filterNames, aggregator.filterInclude, aggregator.filterExact = opts.Filter.exactSet()
+	return aggregator
+}
+
+// Aggregate the lines of the chunk, returning the first malformed line unless the
+// options are lenient
+func (a *chunkAggregator) add(chunk chunk) *LineError {
+	output, stats, hasher := a.output, &a.stats, a.hasher
+	delimiter, scale, lenient, window := a.opts.Delimiter, a.opts.Scale, a.opts.Lenient, a.opts.Window
+	filterNames, filterInclude, filterExact := a.filterNames, a.filterInclude, a.filterExact
+
+	offset := chunk.offset
+	line := chunk.line
+	for lineBytes := range bytes.SplitSeq(chunk.data, []byte("\n")) {
+		lineOffset, lineNumber := offset, line
+		offset += int64(len(lineBytes)) + 1
+		line++
+
+		// Normalize CRLF line endings and skip blank lines, including the empty
+		// line after the trailing newline of the last chunk
+		lineBytes = bytes.TrimSuffix(lineBytes, []byte("\r"))
+		if len(lineBytes) == 0 {
+			continue
+		}
+
+		// Split off the timestamp, the rest of the line is the usual city and
+		// temperature
+		fields := lineBytes
+		var windowStart int64
+		var var32 int32
+		var kind lineErrorKind
+		valid := true
+		if window != nil {
+			if tsIdx := bytes.IndexByte(lineBytes, delimiter); tsIdx < 0 {
+				kind, valid = errMissingDelimiter, false
+			} else if timestamp, ok := window.parseTimestamp(lineBytes[:tsIdx]); !ok {
+				kind, valid = errInvalidTimestamp, false
+			} else {
+				windowStart = window.start(timestamp).Unix()
+				fields = lineBytes[tsIdx+1:]
+			}
+		}
+
+		idx := bytes.IndexByte(fields, delimiter)
+		switch {
+		case !valid:
+		case idx < 0:
+			kind, valid = errMissingDelimiter, false
+		case idx == 0:
+			kind, valid = errEmptyCity, false
+		default:
+			var32, kind, valid = parseTemperature(fields[idx+1:], scale)
+		}
+
+		if !valid {
+			if lenient {
+				stats.Skipped[kind]++
+				continue
+			}
+			return &LineError{Kind: kind, Line: lineNumber, Offset: lineOffset, Text: string(lineBytes)}
+		}
+
+		keyBytes := fields[:idx]
+		stats.Rows++
+
+		// Fast path for exact city filters, skipping the hashing and map updates
+		// of the cities that are filtered out of the output anyway
+		if filterExact && filterNames[string(keyBytes)] != filterInclude {
+			continue
+		}
+
+		hasher.Write(keyBytes)
+		key := int64(hasher.Sum64())
+		hasher.Reset()
+
+		var64 := int64(var32)
+
+		values := output
+		if window != nil {
+			if values = a.windows[windowStart]; values == nil {
+				values = make(Result)
+				a.windows[windowStart] = values
+			}
+		}
+
+		if val, found := values[key]; !found {
+			values[key] = &ValuesV3{City: string(keyBytes), Min: var32, Sum: var64, Max: var32, Count: 1}
+		} else {
+			// Min eval
+			if val.Min > var32 {
+				val.Min = var32
+			}
+
+			// Mean eval
+			val.Sum += var64
+			val.Count++
+
+			// Max eval
+			if val.Max < var32 {
+				val.Max = var32
+			}
+		}
+	}
+	return nil
+}
+
+// The chunked pipeline of V11, the reader hands chunks of lines over to the workers
+// which each track their own map that are merged at the end. Malformed lines fail
+// the run with their position unless the options are lenient, in which case they
+// are skipped and counted in the returned stats
+func aggregate(r io.Reader, opts Options) (Result, Stats, error) {
+	return aggregateContext(context.Background(), r, opts)
+}
+
+// The pipeline of aggregate, stopping early once the context is cancelled. The reader
+// stops handing out chunks and the workers still aggregate the chunks already queued,
+// returning the values of every chunk handed out. Callers check the context to tell a
+// partial result apart
+func aggregateContext(ctx context.Context, r io.Reader, opts Options) (Result, Stats, error) {
+	aggregators, stats, err := runPipeline(ctx, r, opts)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	values := make(map[int64]*ValuesV3, 1000)
+	for _, aggregator := range aggregators {
+		mergeResult(values, aggregator.output)
+	}
+	return values, stats, nil
+}
+
+// The same pipeline as aggregateContext for lines with a leading timestamp column,
+// returning the values per time bucket of the window in the options
+func aggregateWindows(ctx context.Context, r io.Reader, opts Options) (WindowResult, Stats, error) {
+	aggregators, stats, err := runPipeline(ctx, r, opts)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	windows := make(WindowResult)
+	for _, aggregator := range aggregators {
+		mergeWindows(windows, aggregator.windows)
+	}
+	return windows, stats, nil
+}
+
+// Recover a panicking worker goroutine, which the server can't recover from like a
+// panic of the version itself. The panic is kept as an error to fail the run with once
+// the workers are done, and the chunks left are drained so the reader is never blocked
+func recoverWorker[T any](input <-chan T, err *error, failed *atomic.Bool) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("worker panicked: %v", r)
+		failed.Store(true)
+		for range input {
+		}
+	}
+}
+
+// Run the reader and the workers of the pipeline, returning the aggregators of the
+// workers along with their combined stats
+func runPipeline(ctx context.Context, r io.Reader, opts Options) ([]*chunkAggregator, Stats, error) {
+	// The number of workers to spin up to handle line chunk processing/calculations, mess
+	// around with the number of workers to view the impact. Always keep at least one worker
+	// for single threaded machines
+	workers := max(runtime.NumCPU()-1, 1)
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	linesChan := make(chan chunk, 10000)
+	aggregators := make([]*chunkAggregator, workers)
+	workerErrs := make([]*LineError, workers)
+	workerPanics := make([]error, workers)
+
+	for idx := range workers {
+		wg.Add(1)
+		aggregators[idx] = newChunkAggregator(make(map[int64]*ValuesV3), opts)
+		go func(wg *sync.WaitGroup, input chan chunk, aggregator *chunkAggregator, lineErr **LineError, progress *workerProgress) {
+			defer wg.Done()
+			defer recoverWorker(input, &workerPanics[idx], &failed)
+			for chunk := range input {
+				// Keep draining the channel after a failure so the reader is never blocked
+				if *lineErr != nil {
+					continue
+				}
+
+				rows := aggregator.stats.Rows
+				if *lineErr = aggregator.add(chunk); *lineErr != nil {
+					failed.Store(true)
+				}
+				if progress != nil {
+					progress.Rows.Add(aggregator.stats.Rows - rows)
+				}
+			}
+		}(&wg, linesChan, aggregators[idx], &workerErrs[idx], opts.Progress.worker(idx))
+	}
+
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	// Create chunks of 1000 lines instead of reading line by line, mess around with line
+	// chunks to view the impact
+	linesPerChunk := 1000
+	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		newlineCount := 0
+		lastNewlineIndex := -1
+		for i, b := range data {
+			if b == '\n' {
+				newlineCount++
+				lastNewlineIndex = i
+			}
+			if newlineCount >= linesPerChunk {
+				return lastNewlineIndex + 1, data[:lastNewlineIndex], nil
+			}
+		}
+
+		if atEOF {
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	})
+
+	// Every chunk but the last holds exactly linesPerChunk lines, so the line number of
+	// each chunk is known up front without counting the newlines again
+	var offset, line int64 = 0, 1
+	for !failed.Load() && ctx.Err() == nil && scanner.Scan() {
+		chunkBytes := scanner.Bytes()
+		chunkCopy := make([]byte, len(chunkBytes))
+		copy(chunkCopy, chunkBytes)
+		linesChan <- chunk{data: chunkCopy, offset: offset, line: line}
+		offset += int64(len(chunkBytes)) + 1
+		line += int64(linesPerChunk)
+	}
+
+	close(linesChan)
+	wg.Wait()
+
+	for _, err := range workerPanics {
+		if err != nil {
+			return nil, Stats{}, err
+		}
+	}
+
+	// Report the first malformed line in the input when multiple workers failed
+	var firstErr *LineError
+	for _, lineErr := range workerErrs {
+		if lineErr != nil && (firstErr == nil || lineErr.Offset < firstErr.Offset) {
+			firstErr = lineErr
+		}
+	}
+	if firstErr != nil {
+		return nil, Stats{}, firstErr
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, Stats{}, err
+	}
+
+	var stats Stats
+	for _, aggregator := range aggregators {
+		stats.add(aggregator.stats)
+	}
+	return aggregators, stats, nil
+}
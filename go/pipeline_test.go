@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Blank lines still count towards the line numbers reported for malformed lines
+func TestLineErrorPosition(t *testing.T) {
+	input := "Hamburg;12.0\r\n\r\nBulawayo\r\nPalembang;38.8\r\n"
+	_, _, err := aggregate(strings.NewReader(input), defaultOptions())
+	lineErr, ok := err.(*LineError)
+	if !ok {
+		t.Fatalf("got error %v, want a LineError", err)
+	}
+	if lineErr.Line != 3 || lineErr.Offset != 16 || lineErr.Kind != errMissingDelimiter {
+		t.Errorf("got %v", lineErr)
+	}
+}
+
+// Lenient mode skips the malformed lines and counts them per kind
+func TestLenientSummary(t *testing.T) {
+	input := "Hamburg;12.0\nBulawayo\n;8.9\nPalembang;\nHamburg;1.x\nOslo;1.y\nHamburg;-1.0\n"
+	opts := defaultOptions()
+	opts.Lenient = true
+	result, stats, err := aggregate(strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || stats.Rows != 2 {
+		t.Errorf("got %d cities and %d rows, want only the 2 rows of Hamburg", len(result), stats.Rows)
+	}
+	want := "skipped 5 malformed lines of 7: 1 missing delimiter, 1 empty city, 1 empty value, 2 invalid value"
+	if got := stats.summary(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDelimiterAndScale(t *testing.T) {
+	input := "Hamburg\t12.05\nHamburg\t-1.5\nBulawayo\t8\nHamburg\t0.10\n"
+	opts := Options{Delimiter: '\t', Scale: 2}
+	result, _, err := aggregate(strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hamburg := result[cityKey([]byte("Hamburg"))]
+	if hamburg.Min != -150 || hamburg.Max != 1205 || hamburg.Sum != 1065 {
+		t.Errorf("got Hamburg min %d max %d sum %d", hamburg.Min, hamburg.Max, hamburg.Sum)
+	}
+	if bulawayo := result[cityKey([]byte("Bulawayo"))]; bulawayo.Min != 800 {
+		t.Errorf("got Bulawayo min %d", bulawayo.Min)
+	}
+
+	// More fractional digits than the scale can't be represented
+	_, _, err = aggregate(strings.NewReader("Hamburg\t1.234\n"), opts)
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Kind != errInvalidValue {
+		t.Errorf("got error %v, want an invalid value", err)
+	}
+}
+
+func TestExactFilterFastPath(t *testing.T) {
+	filter := &CityFilter{
+		Include: cityMatcher{names: map[string]bool{"Hamburg": true, "Palembang": true}},
+		Exclude: cityMatcher{names: map[string]bool{"Palembang": true}},
+	}
+	opts := defaultOptions()
+	opts.Filter = filter
+	result, stats, err := aggregate(strings.NewReader(unixMeasurements), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[cityKey([]byte("Hamburg"))] == nil {
+		t.Errorf("got %d cities, want only Hamburg", len(result))
+	}
+	if stats.Rows != 6 {
+		t.Errorf("got %d rows, want 6", stats.Rows)
+	}
+}
+
+// A panicking worker fails the run instead of the process, an out of range scale is
+// otherwise only rejected by the command line flags
+func TestWorkerPanic(t *testing.T) {
+	opts := Options{Delimiter: ';', Scale: maxScale + 1}
+	_, _, err := aggregate(strings.NewReader(unixMeasurements), opts)
+	if err == nil || !strings.Contains(err.Error(), "worker panicked") {
+		t.Errorf("got error %v, want the panic of the worker", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"strings"
+)
+
+// Profiles -profile can record, all but the trace are pprof profiles
+var profileKinds = []string{"cpu", "heap", "allocs", "block", "mutex", "trace"}
+
+// Records the profiles of a run into files named after the kind and the version, like
+// cpu-V11.prof or trace-V11.out, so runs of different versions can be compared
+type profiler struct {
+	dir     string
+	version string
+	kinds   []string
+	cpu     *os.File
+	trace   *os.File
+}
+
+// Parse the comma separated profile kinds of the -profile flag
+func parseProfileKinds(list string) ([]string, error) {
+	var kinds []string
+	for kind := range strings.SplitSeq(list, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			continue
+		}
+		if !slices.Contains(profileKinds, kind) {
+			return nil, fmt.Errorf("unknown profile %q, expected %s", kind, strings.Join(profileKinds, ", "))
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func (p *profiler) path(kind string) string {
+	if kind == "trace" {
+		return filepath.Join(p.dir, fmt.Sprintf("trace-%s.out", p.version))
+	}
+	return filepath.Join(p.dir, fmt.Sprintf("%s-%s.prof", kind, p.version))
+}
+
+// Start recording the profiles, the block and mutex rates are only set when those
+// profiles are recorded as they slow down every blocking call and lock
+func startProfiles(kinds []string, dir string, version string, blockRate int, mutexFraction int) (*profiler, error) {
+	p := &profiler{dir: dir, version: version, kinds: kinds}
+	for _, kind := range kinds {
+		var err error
+		switch kind {
+		case "cpu":
+			if p.cpu, err = os.Create(p.path(kind)); err == nil {
+				err = pprof.StartCPUProfile(p.cpu)
+			}
+		case "trace":
+			if p.trace, err = os.Create(p.path(kind)); err == nil {
+				err = trace.Start(p.trace)
+			}
+		case "block":
+			runtime.SetBlockProfileRate(blockRate)
+		case "mutex":
+			runtime.SetMutexProfileFraction(mutexFraction)
+		}
+		if err != nil {
+			p.stop()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Stop the CPU profile and trace and write the other profiles as of now, the heap
+// profile after a garbage collection so it shows the live heap at the end of the run,
+// then turn the block and mutex sampling off again
+func (p *profiler) stop() error {
+	var errs []error
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		errs = append(errs, p.cpu.Close())
+		p.cpu = nil
+	}
+	if p.trace != nil {
+		trace.Stop()
+		errs = append(errs, p.trace.Close())
+		p.trace = nil
+	}
+
+	for _, kind := range p.kinds {
+		if kind == "cpu" || kind == "trace" {
+			continue
+		}
+		if kind == "heap" {
+			runtime.GC()
+		}
+		file, err := os.Create(p.path(kind))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, pprof.Lookup(kind).WriteTo(file, 0), file.Close())
+	}
+	if slices.Contains(p.kinds, "block") {
+		runtime.SetBlockProfileRate(0)
+	}
+	if slices.Contains(p.kinds, "mutex") {
+		runtime.SetMutexProfileFraction(0)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	kinds, err := parseProfileKinds("cpu, Heap,allocs,block,mutex,trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(kinds, profileKinds) {
+		t.Fatalf("got kinds %v, want %v", kinds, profileKinds)
+	}
+	if _, err := parseProfileKinds("cpu,goroutines"); err == nil {
+		t.Error("got no error for an unknown profile")
+	}
+
+	dir := t.TempDir()
+	profiles, err := startProfiles(kinds, dir, "V11", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := aggregate(strings.NewReader(manyMeasurements()), options); err != nil {
+		t.Fatal(err)
+	}
+	if err := profiles.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"cpu-V11.prof", "heap-V11.prof", "allocs-V11.prof", "block-V11.prof", "mutex-V11.prof", "trace-V11.out"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if info.Size() == 0 {
+			t.Errorf("%s is empty", name)
+		}
+	}
+}
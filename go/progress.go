@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Rows a V11 worker aggregated so far
+type workerProgress struct {
+	Rows atomic.Int64
+}
+
+// Counters of how far a run got, updated by the inputs as they're read and by the V11
+// workers as they aggregate their chunks
+type Progress struct {
+	// Size of the inputs, 0 when unknown as some are compressed
+	Total int64
+	Bytes atomic.Int64
+	Lines atomic.Int64
+	// One per V11 worker, the workers of inputs aggregated at the same time share them
+	Workers []workerProgress
+}
+
+func newProgress(total int64) *Progress {
+	return &Progress{Total: total, Workers: make([]workerProgress, max(runtime.NumCPU()-1, 1))}
+}
+
+// The counters of the V11 worker, nil when the progress isn't tracked
+func (p *Progress) worker(idx int) *workerProgress {
+	if p == nil || idx >= len(p.Workers) {
+		return nil
+	}
+	return &p.Workers[idx]
+}
+
+// Total size of the files, 0 when one of them is compressed as only the decompressed
+// bytes are counted while reading
+func inputsSize(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0
+		}
+		info, err := file.Stat()
+		if err == nil {
+			var decompressor io.Reader
+			decompressor, err = detectCompression(bufio.NewReader(file))
+			if decompressor != nil {
+				err = fmt.Errorf("%s is compressed", path)
+			}
+		}
+		file.Close()
+		if err != nil {
+			return 0
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+// Format a count with a metric suffix, 1.5M for 1,500,000
+func humanize(val float64) string {
+	for _, suffix := range []string{"", "k", "M", "G"} {
+		if val < 1000 {
+			return fmt.Sprintf("%.1f%s", val, suffix)
+		}
+		val /= 1000
+	}
+	return fmt.Sprintf("%.1fT", val)
+}
+
+// A line describing the progress, elapsed since the start and interval since the
+// previous counters were taken for the per-worker throughput
+func (p *Progress) line(elapsed time.Duration, interval time.Duration, prevWorkers []int64) string {
+	bytes := float64(p.Bytes.Load())
+	seconds := elapsed.Seconds()
+
+	var line strings.Builder
+	if p.Total > 0 {
+		fmt.Fprintf(&line, "%5.1f%% %sB of %sB", 100*bytes/float64(p.Total), humanize(bytes), humanize(float64(p.Total)))
+	} else {
+		fmt.Fprintf(&line, "%sB", humanize(bytes))
+	}
+	fmt.Fprintf(&line, ", %s rows/s", humanize(float64(p.Lines.Load())/seconds))
+	if p.Total > 0 && bytes > 0 {
+		remaining := time.Duration((float64(p.Total) - bytes) / (bytes / seconds) * float64(time.Second))
+		fmt.Fprintf(&line, ", ETA %s", remaining.Round(time.Second))
+	}
+
+	// Only V11 counts rows per worker, the other versions leave them at zero
+	var workers strings.Builder
+	var total int64
+	for idx := range p.Workers {
+		rows := p.Workers[idx].Rows.Load()
+		fmt.Fprintf(&workers, " %s", humanize(float64(rows-prevWorkers[idx])/interval.Seconds()))
+		total += rows
+		prevWorkers[idx] = rows
+	}
+	if total > 0 {
+		fmt.Fprintf(&line, ", workers rows/s%s", workers.String())
+	}
+	return line.String()
+}
+
+// Write the progress to the writer every interval, overwriting the previous line, until
+// the returned function is called which ends the line
+func (p *Progress) report(w io.Writer, interval time.Duration) func() {
+	start := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		prevWorkers := make([]int64, len(p.Workers))
+		last := start
+		for {
+			select {
+			case <-done:
+				// Finish with the final counters on a line of their own
+				now := time.Now()
+				fmt.Fprintf(w, "\r%s\033[K\n", p.line(now.Sub(start), now.Sub(last), prevWorkers))
+				return
+			case now := <-ticker.C:
+				// Clear the rest of the previous line in case the new one is shorter
+				fmt.Fprintf(w, "\r%s\033[K", p.line(now.Sub(start), now.Sub(last), prevWorkers))
+				last = now
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	progress := &Progress{Total: 4_000_000, Workers: make([]workerProgress, 2)}
+	progress.Bytes.Store(1_000_000)
+	progress.Lines.Store(50_000)
+	progress.Workers[0].Rows.Store(20_000)
+	progress.Workers[1].Rows.Store(30_000)
+
+	prevWorkers := []int64{10_000, 0}
+	got := progress.line(2*time.Second, time.Second, prevWorkers)
+	want := " 25.0% 1.0MB of 4.0MB, 25.0k rows/s, ETA 6s, workers rows/s 10.0k 30.0k"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if prevWorkers[0] != 20_000 || prevWorkers[1] != 30_000 {
+		t.Errorf("got previous worker rows %v", prevWorkers)
+	}
+
+	// Compressed inputs have no known size to compare against
+	progress.Total = 0
+	progress.Workers = nil
+	if got, want := progress.line(2*time.Second, time.Second, nil), "1.0MB, 25.0k rows/s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// The first paragraph of the doc comment of every version in the source file, the
+// timings below it are what the report replaces
+func versionDescriptions(source string) (map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), source, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	descriptions := make(map[string]string)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Doc == nil {
+			continue
+		}
+		if _, found := findVersion(fn.Name.Name); !found {
+			continue
+		}
+		paragraph, _, _ := strings.Cut(fn.Doc.Text(), "\n\n")
+		descriptions[fn.Name.Name] = strings.Join(strings.Fields(paragraph), " ")
+	}
+	return descriptions, nil
+}
+
+func mean(timings []time.Duration) time.Duration {
+	var total time.Duration
+	for _, timing := range timings {
+		total += timing
+	}
+	return total / time.Duration(max(len(timings), 1))
+}
+
+// Format an average like the version notes, 1minute 49seconds
+func formatAverage(d time.Duration) string {
+	if d < time.Minute {
+		return formatSeconds(d)
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%dminute %dseconds", int64(d.Minutes()), int64(d.Seconds())%60)
+}
+
+// A Markdown section per version with its description and a block per machine it was
+// run on, holding the average time and hotspots of its latest run there and the change
+// against the previous version run over the same data on that machine. The machines are
+// in the order they first appear in the history
+func formatReport(records []BenchRecord, descriptions map[string]string) string {
+	var machines []BenchMachine
+	latest := make(map[BenchMachine]map[string]BenchRecord)
+	for _, record := range records {
+		if latest[record.Machine] == nil {
+			machines = append(machines, record.Machine)
+			latest[record.Machine] = make(map[string]BenchRecord)
+		}
+		latest[record.Machine][record.Version] = record
+	}
+
+	var builder strings.Builder
+	builder.WriteString("## Versions\n")
+	previous := make(map[BenchMachine]BenchRecord)
+	for _, version := range versions {
+		var runs []BenchRecord
+		for _, machine := range machines {
+			if record, found := latest[machine][version.Name]; found {
+				runs = append(runs, record)
+			}
+		}
+		if len(runs) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&builder, "\n### %s\n", version.Name)
+		if description := descriptions[version.Name]; description != "" {
+			fmt.Fprintf(&builder, "\n%s\n", description)
+		}
+		for _, record := range runs {
+			average := mean(record.Timings)
+			fmt.Fprintf(&builder, "\n#### Timings on %s\n\n", record.Machine.Hostname)
+			if prev, found := previous[record.Machine]; found && prev.Bytes == record.Bytes {
+				change := mean(prev.Timings) - average
+				direction := "decreased"
+				if change < 0 {
+					direction, change = "increased", -change
+				}
+				fmt.Fprintf(&builder, "Compared to %s the average time %s by %s (%.1f%%), now at %s\n\n",
+					prev.Version, direction, formatAverage(change), 100*float64(change)/float64(mean(prev.Timings)), formatAverage(average))
+			}
+			fmt.Fprintf(&builder, "%d runs over %d rows on %s (%d threads, %s) at %s\n\n",
+				len(record.Timings), record.Rows, record.Machine.CPU, record.Machine.NumCPU, record.Machine.GOOS, shortCommit(record.Commit))
+			fmt.Fprintf(&builder, "```\nAverage time %s\n", formatAverage(average))
+			for _, spot := range record.Hotspots {
+				fmt.Fprintf(&builder, "%s %s\n", spot.Name, formatSeconds(spot.Flat))
+			}
+			builder.WriteString("```\n")
+			previous[record.Machine] = record
+		}
+	}
+	return builder.String()
+}
+
+// The report subcommand, printing the version notes of the README from the bench history
+func report(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	historyPath := flags.String("history", benchHistoryPath, "JSON lines file written by the bench subcommand")
+	source := flags.String("source", "main.go", "Go file the version descriptions are read from, empty to leave them out")
+	host := flags.String("host", "", "host name of the only machine to report, every machine of the history when empty")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s report [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	file, err := os.Open(*historyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	records, err := readBenchHistory(file)
+	file.Close()
+	if err != nil {
+		log.Fatal(fmt.Errorf("%s: %w", *historyPath, err))
+	}
+	if *host != "" {
+		var hostRecords []BenchRecord
+		for _, record := range records {
+			if record.Machine.Hostname == *host {
+				hostRecords = append(hostRecords, record)
+			}
+		}
+		records = hostRecords
+	}
+
+	descriptions := map[string]string{}
+	if *source != "" {
+		if descriptions, err = versionDescriptions(*source); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Print(formatReport(records, descriptions))
+}
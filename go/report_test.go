@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionDescriptions(t *testing.T) {
+	descriptions, err := versionDescriptions("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := descriptions["V1"], "Super basic tracking and parsing, first go hacking something together"; got != want {
+		t.Errorf("got V1 description %q, want %q", got, want)
+	}
+	// Multi-line paragraphs are joined and the timings left out
+	if got := descriptions["V6"]; !strings.HasSuffix(got, "only at the end") {
+		t.Errorf("got V6 description %q", got)
+	}
+	if _, found := descriptions["V12"]; found {
+		t.Error("got a description of the unregistered V12")
+	}
+}
+
+func TestFormatReport(t *testing.T) {
+	desktop := BenchMachine{Hostname: "desktop", CPU: "Ryzen", NumCPU: 16, GOOS: "windows"}
+	laptop := BenchMachine{Hostname: "laptop", CPU: "M2", NumCPU: 8, GOOS: "darwin"}
+	records := []BenchRecord{
+		{Version: "V2", Commit: "a", Machine: desktop, Bytes: 100, Rows: 10, Timings: []time.Duration{70 * time.Second, 64 * time.Second}},
+		{Version: "V1", Commit: "a", Machine: desktop, Bytes: 100, Rows: 10, Timings: []time.Duration{109 * time.Second}, Hotspots: []Hotspot{
+			{Name: "runtime.mapaccess2_faststr", Flat: 37 * time.Second},
+			{Name: "strings.Split", Flat: 26 * time.Second},
+		}},
+		// Only the latest run of a version on a machine is reported
+		{Version: "V2", Commit: "b", Machine: desktop, Bytes: 100, Rows: 10, Timings: []time.Duration{67 * time.Second}},
+		// Compared against the previous version on the same machine only
+		{Version: "V2", Commit: "b", Machine: laptop, Bytes: 100, Rows: 10, Timings: []time.Duration{97 * time.Second}},
+	}
+	descriptions := map[string]string{"V1": "Super basic tracking"}
+
+	want := "## Versions\n" +
+		"\n### V1\n" +
+		"\nSuper basic tracking\n" +
+		"\n#### Timings on desktop\n\n" +
+		"1 runs over 10 rows on Ryzen (16 threads, windows) at a\n\n" +
+		"```\nAverage time 1minute 49seconds\nruntime.mapaccess2_faststr 37seconds\nstrings.Split 26seconds\n```\n" +
+		"\n### V2\n" +
+		"\n#### Timings on desktop\n\n" +
+		"Compared to V1 the average time decreased by 42seconds (38.5%), now at 1minute 7seconds\n\n" +
+		"1 runs over 10 rows on Ryzen (16 threads, windows) at b\n\n" +
+		"```\nAverage time 1minute 7seconds\n```\n" +
+		"\n#### Timings on laptop\n\n" +
+		"1 runs over 10 rows on M2 (8 threads, darwin) at b\n\n" +
+		"```\nAverage time 1minute 37seconds\n```\n"
+	if got := formatReport(records, descriptions); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// Aggregated values of a run, keyed by the hash of the city name the same way V9
+// onwards keys their maps. Every version returns its values in this form so the
+// output and merging of results is shared between versions
+type Result map[int64]*ValuesV3
+
+// Hash the city name into the key used by Result
+func cityKey(city []byte) int64 {
+	hasher := fnv.New64a()
+	hasher.Write(city)
+	return int64(hasher.Sum64())
+}
+
+// Merge the values of src into dst, the same min/max/sum/count merge used when
+// combining the worker maps. Values new to dst are copied so src is left untouched
+func mergeResult(dst Result, src Result) {
+	for key, val := range src {
+		if finalVal, found := dst[key]; !found {
+			copied := *val
+			dst[key] = &copied
+		} else {
+			if finalVal.Min > val.Min {
+				finalVal.Min = val.Min
+			}
+			finalVal.Sum += val.Sum
+			finalVal.Count += val.Count
+			if finalVal.Max < val.Max {
+				finalVal.Max = val.Max
+			}
+		}
+	}
+}
+
+// Number of measurements aggregated into the result
+func (result Result) rows() int64 {
+	var rows int64
+	for _, value := range result {
+		rows += int64(value.Count)
+	}
+	return rows
+}
+
+// Temperature unit of the output, the measurements are always in Celsius
+type Unit int
+
+const (
+	Celsius Unit = iota
+	Fahrenheit
+	Kelvin
+)
+
+func parseUnit(name string) (Unit, error) {
+	switch strings.ToLower(name) {
+	case "c", "celsius":
+		return Celsius, nil
+	case "f", "fahrenheit":
+		return Fahrenheit, nil
+	case "k", "kelvin":
+		return Kelvin, nil
+	}
+	return Celsius, fmt.Errorf("unknown unit %q, expected C, F or K", name)
+}
+
+// Convert a Celsius temperature in fixed point units of 1/factor degrees to the unit,
+// still in the same fixed point units and left unrounded
+func (unit Unit) convert(val float64, factor float64) float64 {
+	switch unit {
+	case Fahrenheit:
+		return val*9/5 + 32*factor
+	case Kelvin:
+		// 273.15 kept as an integer division so the offset is exact for the scales used
+		return val + 27315*factor/100
+	}
+	return val
+}
+
+// Settings for the final output of a run, set from the command line flags
+type OutputOptions struct {
+	// Number of fractional digits of the fixed point values, also the output precision
+	Scale int
+	// Unit the finalized min/mean/max values are converted to
+	Unit Unit
+	// Cities to include in the output, applied after aggregation
+	Filter *CityFilter
+	// Order of the cities in the output, byte order of the names by default
+	Sort SortOrder
+	// When above zero only output the Top or Bottom cities ranked by the metric
+	Rank   Metric
+	Top    int
+	Bottom int
+}
+
+// Output settings used by main, set from the command line flags
+var outputOptions = OutputOptions{Scale: 1}
+
+// Finalized values of a city, converted to the output unit and rounded to the output
+// precision
+type Station struct {
+	City  string  `json:"city"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+	Count int32   `json:"count"`
+}
+
+// Finalize the result into the cities of the output, filtered and sorted by the sort
+// order, alphabetically by default, or in the order of their rank when ranking the
+// cities. Converting to a different unit only happens here so the workers keep working
+// with the Celsius integers, the converted values are rounded once to the output
+// precision
+func finalize(result Result, opts OutputOptions) []Station {
+	sortedValues := make([]*ValuesV3, 0, len(result))
+	for _, value := range result {
+		if opts.Filter.keep(value.City) {
+			sortedValues = append(sortedValues, value)
+		}
+	}
+	sortValues(sortedValues, opts.Sort)
+	if opts.Top > 0 {
+		sortedValues = rankValues(sortedValues, opts.Rank, opts.Top, true)
+	} else if opts.Bottom > 0 {
+		sortedValues = rankValues(sortedValues, opts.Rank, opts.Bottom, false)
+	}
+
+	unit := opts.Unit
+	factor := math.Pow10(opts.Scale)
+	stations := make([]Station, len(sortedValues))
+	for idx, value := range sortedValues {
+		stations[idx] = Station{
+			City:  value.City,
+			Min:   math.Round(unit.convert(float64(value.Min), factor)) / factor,
+			Mean:  math.Round(unit.convert(float64(value.Sum)/float64(value.Count), factor)) / factor,
+			Max:   math.Round(unit.convert(float64(value.Max), factor)) / factor,
+			Count: value.Count,
+		}
+	}
+	return stations
+}
+
+// Build the challenge output string of the finalized cities with their min/mean/max
+// values, printed with the number of fractional digits of the scale
+func formatResult(result Result, opts OutputOptions) string {
+	stations := finalize(result, opts)
+	scale := opts.Scale
+
+	output := "{"
+	for idx, station := range stations {
+		output += fmt.Sprintf("%s=%.*f/%.*f/%.*f", station.City, scale, station.Min, scale, station.Mean, scale, station.Max)
+		if idx < len(stations)-1 {
+			output += ", "
+		}
+	}
+	output += "}"
+	return output
+}
+
+// The -per-file output, a line per input with its path followed by its result
+func formatFileResults(paths []string, results []Result, opts OutputOptions) string {
+	var builder strings.Builder
+	for idx, result := range results {
+		fmt.Fprintf(&builder, "%s %s\n", paths[idx], formatResult(result, opts))
+	}
+	return builder.String()
+}
+
+// Convert a float temperature into the tenths of a degree stored in ValuesV3
+func toTenths(val float64) int32 {
+	return int32(math.Round(val * 10))
+}
+
+// Convert the separate maps tracked by V1 into a Result
+func valuesV1Result(minVals, meanVals map[string]float64, meanCount map[string]int, maxVals map[string]float64) Result {
+	result := make(Result, len(minVals))
+	for city, minVal := range minVals {
+		result[cityKey([]byte(city))] = &ValuesV3{
+			City:  city,
+			Min:   toTenths(minVal),
+			Max:   toTenths(maxVals[city]),
+			Sum:   int64(math.Round(meanVals[city] * 10)),
+			Count: int32(meanCount[city]),
+		}
+	}
+	return result
+}
+
+// Convert the float Values tracked by V2 to V5 into a Result
+func valuesResult(values map[string]*Values) Result {
+	result := make(Result, len(values))
+	for city, value := range values {
+		result[cityKey([]byte(city))] = &ValuesV3{
+			City:  city,
+			Min:   toTenths(value.Min),
+			Max:   toTenths(value.Max),
+			Sum:   int64(math.Round(value.Sum * 10)),
+			Count: int32(value.Count),
+		}
+	}
+	return result
+}
+
+// Convert the ValuesV2 tracked by V6 to V8 into a Result
+func valuesV2Result(values map[string]*ValuesV2) Result {
+	result := make(Result, len(values))
+	for city, value := range values {
+		result[cityKey([]byte(city))] = &ValuesV3{
+			City:  city,
+			Min:   value.Min,
+			Max:   value.Max,
+			Sum:   value.Sum,
+			Count: value.Count,
+		}
+	}
+	return result
+}
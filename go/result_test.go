@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnitConversion(t *testing.T) {
+	result := Result{
+		cityKey([]byte("Hamburg")): {City: "Hamburg", Min: -400, Max: 123, Sum: -300, Count: 3},
+	}
+	tests := []struct {
+		unit Unit
+		want string
+	}{
+		{Celsius, "{Hamburg=-40.0/-10.0/12.3}"},
+		{Fahrenheit, "{Hamburg=-40.0/14.0/54.1}"},
+		{Kelvin, "{Hamburg=233.2/263.2/285.5}"},
+	}
+	for _, test := range tests {
+		got := formatResult(result, OutputOptions{Scale: 1, Unit: test.unit})
+		if got != test.want {
+			t.Errorf("unit %d: got %s, want %s", test.unit, got, test.want)
+		}
+	}
+}
+
+func TestRanking(t *testing.T) {
+	result := Result{
+		cityKey([]byte("Abha")):     {City: "Abha", Min: -10, Max: 300, Sum: 400, Count: 4},
+		cityKey([]byte("Hamburg")):  {City: "Hamburg", Min: -50, Max: 200, Sum: 150, Count: 3},
+		cityKey([]byte("Bulawayo")): {City: "Bulawayo", Min: 0, Max: 300, Sum: 400, Count: 4},
+	}
+	tests := []struct {
+		opts OutputOptions
+		want string
+	}{
+		{OutputOptions{Scale: 1, Rank: MetricMean, Top: 2}, "{Abha=-1.0/10.0/30.0, Bulawayo=0.0/10.0/30.0}"},
+		{OutputOptions{Scale: 1, Rank: MetricMin, Bottom: 1}, "{Hamburg=-5.0/5.0/20.0}"},
+		{OutputOptions{Scale: 1, Rank: MetricRange, Top: 5}, "{Abha=-1.0/10.0/30.0, Bulawayo=0.0/10.0/30.0, Hamburg=-5.0/5.0/20.0}"},
+		{OutputOptions{Scale: 1, Rank: MetricMax, Bottom: 2}, "{Hamburg=-5.0/5.0/20.0, Abha=-1.0/10.0/30.0}"},
+	}
+	for _, test := range tests {
+		got := formatResult(result, test.opts)
+		if got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	result := Result{}
+	for idx, city := range []string{"Zürich", "Zurich", "Åre", "Abha", "zagreb"} {
+		result[cityKey([]byte(city))] = &ValuesV3{City: city, Min: int32(idx * 10), Max: int32(idx * 10), Sum: int64(idx * 10), Count: 1}
+	}
+	tests := []struct {
+		name   string
+		locale string
+		desc   bool
+		want   string
+	}{
+		{"byte", "", false, "{Abha=3.0/3.0/3.0, Zurich=1.0/1.0/1.0, Zürich=0.0/0.0/0.0, zagreb=4.0/4.0/4.0, Åre=2.0/2.0/2.0}"},
+		{"codepoint", "", true, "{Åre=2.0/2.0/2.0, zagreb=4.0/4.0/4.0, Zürich=0.0/0.0/0.0, Zurich=1.0/1.0/1.0, Abha=3.0/3.0/3.0}"},
+		{"locale", "en", false, "{Abha=3.0/3.0/3.0, Åre=2.0/2.0/2.0, zagreb=4.0/4.0/4.0, Zurich=1.0/1.0/1.0, Zürich=0.0/0.0/0.0}"},
+		{"locale", "sv", false, "{Abha=3.0/3.0/3.0, zagreb=4.0/4.0/4.0, Zurich=1.0/1.0/1.0, Zürich=0.0/0.0/0.0, Åre=2.0/2.0/2.0}"},
+		{"max", "", true, "{zagreb=4.0/4.0/4.0, Abha=3.0/3.0/3.0, Åre=2.0/2.0/2.0, Zurich=1.0/1.0/1.0, Zürich=0.0/0.0/0.0}"},
+	}
+	for _, test := range tests {
+		order, err := parseSortOrder(test.name, test.locale, test.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := formatResult(result, OutputOptions{Scale: 1, Sort: order})
+		if got != test.want {
+			t.Errorf("%s %s: got %s, want %s", test.name, test.locale, got, test.want)
+		}
+	}
+}
+
+// The -per-file output has a line per file in the order of the inputs, next to the
+// merged result of all of them
+func TestPerFileOutput(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "b.txt"), filepath.Join(dir, "a.txt")}
+	os.WriteFile(paths[0], []byte("Hamburg;12.0\nOslo;-3.0\n"), 0o644)
+	os.WriteFile(paths[1], []byte("Hamburg;2.0\n"), 0o644)
+
+	version, _ := findVersion("V11")
+	results, err := aggregateFiles(paths, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := OutputOptions{Scale: 1}
+	want := paths[0] + " {Hamburg=12.0/12.0/12.0, Oslo=-3.0/-3.0/-3.0}\n" + paths[1] + " {Hamburg=2.0/2.0/2.0}\n"
+	if got := formatFileResults(paths, results, opts); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	merged := make(Result)
+	for _, result := range results {
+		mergeResult(merged, result)
+	}
+	if got, want := formatResult(merged, opts), "{Hamburg=2.0/7.0/12.0, Oslo=-3.0/-3.0/-3.0}"; got != want {
+		t.Errorf("got merged %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// JSON response of the aggregate endpoint
+type serveResponse struct {
+	Version  string    `json:"version"`
+	Elapsed  string    `json:"elapsed"`
+	Stations []Station `json:"stations"`
+}
+
+// Serves the versions over HTTP so the aggregation can be used as a service instead of
+// shelling out to the binary
+type server struct {
+	version Version
+	// Directory the path query parameter is resolved in, referencing server local
+	// files is disabled when empty
+	dataDir string
+	// Buffered to the number of jobs allowed to run at the same time
+	jobs chan struct{}
+}
+
+func newServer(version Version, dataDir string, jobs int) *server {
+	return &server{version: version, dataDir: dataDir, jobs: make(chan struct{}, jobs)}
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("POST /aggregate", s.handleAggregate)
+	return mux
+}
+
+// Parse the output settings of a request from its query parameters, mirroring the
+// command line flags of the same names
+func parseOutputQuery(query url.Values) (OutputOptions, error) {
+	opts := OutputOptions{Scale: options.Scale}
+	var err error
+
+	if opts.Unit, err = parseUnit(valueOr(query, "unit", "C")); err != nil {
+		return opts, err
+	}
+	descending := query.Get("desc") == "true"
+	if opts.Sort, err = parseSortOrder(valueOr(query, "sort", "byte"), valueOr(query, "locale", "en"), descending); err != nil {
+		return opts, err
+	}
+	if opts.Rank, err = parseMetric(valueOr(query, "rank", "mean")); err != nil {
+		return opts, err
+	}
+	for name, limit := range map[string]*int{"top": &opts.Top, "bottom": &opts.Bottom} {
+		if query.Has(name) {
+			if *limit, err = strconv.Atoi(query.Get(name)); err != nil {
+				return opts, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			if *limit < 0 {
+				return opts, fmt.Errorf("invalid %s: %d is negative", name, *limit)
+			}
+		}
+	}
+
+	var filter CityFilter
+	if filter.Include, err = newCityMatcher("", query.Get("include-prefix"), query.Get("include-regexp")); err != nil {
+		return opts, err
+	}
+	if filter.Exclude, err = newCityMatcher("", query.Get("exclude-prefix"), query.Get("exclude-regexp")); err != nil {
+		return opts, err
+	}
+	if !filter.Include.empty() || !filter.Exclude.empty() {
+		opts.Filter = &filter
+	}
+	return opts, nil
+}
+
+func valueOr(query url.Values, name string, fallback string) string {
+	if query.Has(name) {
+		return query.Get(name)
+	}
+	return fallback
+}
+
+// A request the server refuses to run, as opposed to failing to run it
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// The status code of a failed request. Refused requests, malformed lines and corrupt
+// compressed input are the client's fault, everything else like failing to read a file
+// or a version panicking is the server's
+func errorStatus(err error) int {
+	var reqErr *requestError
+	var lineErr *LineError
+	var bzip2Err bzip2.StructuralError
+	switch {
+	case errors.As(err, &reqErr), errors.As(err, &lineErr), errors.As(err, &bzip2Err),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum):
+		return http.StatusBadRequest
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// Open the input of a request, either the server local file referenced by the path
+// query parameter or the request body, decompressing gzip and bzip2 input either way
+func (s *server) openInput(r *http.Request) (io.ReadCloser, error) {
+	if path := r.URL.Query().Get("path"); path != "" {
+		if s.dataDir == "" {
+			return nil, &requestError{message: "referencing server local files is disabled"}
+		}
+		if !filepath.IsLocal(path) {
+			return nil, &requestError{message: fmt.Sprintf("path %q is outside of the data directory", path)}
+		}
+		return openMeasurements(filepath.Join(s.dataDir, path))
+	}
+
+	reader := bufio.NewReaderSize(r.Body, 1024*1024)
+	decompressor, err := detectCompression(reader)
+	if err != nil {
+		return nil, err
+	}
+	if decompressor == nil {
+		// Keep reading through the buffered reader as it holds the peeked bytes
+		return io.NopCloser(reader), nil
+	}
+	return decompressAsync(decompressor), nil
+}
+
+// Run the selected version, stopping once the client goes away. V11 runs through
+// aggregate directly so its workers stop right away, the other versions stop at the
+// end of the current line. A version panicking fails the request instead of the server,
+// the workers of V8, V10 and V11 recover their own panics and return them as errors
+func (s *server) run(ctx context.Context, input io.Reader) (result Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("%s panicked: %v", s.version.Name, r)
+		}
+	}()
+
+	if s.version.Name != "V11" {
+		result, err = s.version.Run(newCancelReader(ctx, io.NopCloser(input), nil))
+	} else {
+		result, _, err = aggregateContext(ctx, input, options)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+func (s *server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseOutputQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for a free job slot, giving up when the client goes away
+	select {
+	case s.jobs <- struct{}{}:
+		defer func() { <-s.jobs }()
+	case <-r.Context().Done():
+		return
+	}
+
+	input, err := s.openInput(r)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	defer input.Close()
+
+	start := time.Now()
+	result, err := s.run(r.Context(), input)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("%s %s: %v", r.Method, r.URL, err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(serveResponse{
+		Version:  s.version.Name,
+		Elapsed:  time.Since(start).String(),
+		Stations: finalize(result, opts),
+	})
+	if err != nil {
+		// The status is already sent, so the response can only be logged as cut short
+		log.Printf("%s %s: writing the response: %v", r.Method, r.URL, err)
+	}
+}
+
+// The serve subcommand, running the selected version over the bodies posted to
+// /aggregate and returning the finalized cities as JSON
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	versionName := flags.String("version", "V11", "version to run, V1 through V11")
+	jobs := flags.Int("jobs", 2, "number of aggregations allowed to run at the same time")
+	dataDir := flags.String("data-dir", "", "directory of the server local files the path query parameter can reference")
+	flags.BoolVar(&options.Lenient, "lenient", false, "skip and count malformed lines instead of failing on the first one (V11)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s serve [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output(), "\nPOST /aggregate with the measurements as the body or ?path= of a file in the data directory")
+		fmt.Fprintln(flags.Output(), "Output query parameters: unit, sort, desc, locale, rank, top, bottom, include-prefix,")
+		fmt.Fprintln(flags.Output(), "include-regexp, exclude-prefix and exclude-regexp, matching the command line flags")
+	}
+	flags.Parse(args)
+
+	version, found := findVersion(*versionName)
+	if !found {
+		log.Fatalf("unknown version %q", *versionName)
+	}
+	if *jobs < 1 {
+		log.Fatalf("at least one job has to be allowed, got %d", *jobs)
+	}
+	if options.Lenient && version.Name != "V11" {
+		log.Fatal("-lenient is only supported by V11")
+	}
+
+	srv := newServer(version, *dataDir, *jobs)
+	log.Printf("Serving %s on %s", version.Name, *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv.routes()))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestServeAggregate(t *testing.T) {
+	version, _ := findVersion("V11")
+	srv := httptest.NewServer(newServer(version, "", 1).routes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got healthz status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/aggregate?top=1&rank=max", "text/plain", strings.NewReader(unixMeasurements))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body serveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Stations) != 1 || body.Stations[0].City != "Palembang" || body.Stations[0].Max != 38.8 {
+		t.Errorf("got %+v", body.Stations)
+	}
+
+	resp, err = http.Post(srv.URL+"/aggregate", "text/plain", strings.NewReader("Hamburg\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for a malformed body", resp.StatusCode)
+	}
+}
+
+// Failures of the server answer with 500 and malformed input with 400, for every version
+func TestServeErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		run     func(io.Reader) (Result, error)
+		path    string
+		body    string
+		dataDir string
+		status  int
+	}{
+		{name: "V1", run: V1, body: "Hamburg\n", status: http.StatusBadRequest},
+		{name: "V8", run: V8, body: "Hamburg;12.0\n;1.0\n", status: http.StatusBadRequest},
+		{name: "gzip", run: V11, body: "\x1f\x8b not gzip", status: http.StatusBadRequest},
+		{name: "disabled", run: V11, path: "measurements.txt", status: http.StatusBadRequest},
+		{name: "missing", run: V11, path: "missing.txt", dataDir: t.TempDir(), status: http.StatusNotFound},
+		{name: "failing", run: func(io.Reader) (Result, error) { return nil, errors.New("disk on fire") }, status: http.StatusInternalServerError},
+		{name: "panicking", run: func(io.Reader) (Result, error) { panic("boom") }, status: http.StatusInternalServerError},
+	} {
+		srv := httptest.NewServer(newServer(Version{Name: test.name, Run: test.run}, test.dataDir, 1).routes())
+		url := srv.URL + "/aggregate"
+		if test.path != "" {
+			url += "?path=" + test.path
+		}
+		resp, err := http.Post(url, "text/plain", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestParseOutputQuery(t *testing.T) {
+	opts, err := parseOutputQuery(url.Values{"top": {"3"}, "rank": {"max"}})
+	if err != nil || opts.Top != 3 || opts.Rank != MetricMax {
+		t.Errorf("got %+v, %v", opts, err)
+	}
+	for _, query := range []string{"top=-1", "bottom=-3", "top=x", "unit=X"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseOutputQuery(values); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+// The versions before V11 stop reading once the client goes away
+func TestServeRunCancelled(t *testing.T) {
+	version, _ := findVersion("V1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := newServer(version, "", 1).run(ctx, strings.NewReader(manyMeasurements()))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want the run cancelled", err)
+	}
+}
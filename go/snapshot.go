@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var snapshotMagic = []byte("1BRS")
+
+const snapshotVersion = 1
+
+// Number of bytes at the start of a file its fingerprint covers
+const fingerprintSize = 4096
+
+// Aggregated values of every file folded in so far, saved between runs so a later run
+// only has to aggregate new files or the tail appended to a known file
+type Snapshot struct {
+	// Number of fractional digits of the fixed point values
+	Scale int
+	// Files folded in so far per absolute file path
+	Files  map[string]SnapshotFile
+	Values Result
+}
+
+// How much of a file was folded into a snapshot
+type SnapshotFile struct {
+	// Bytes of complete lines already aggregated
+	Offset int64
+	// Hash of the aggregated bytes up to the first fingerprintSize, which appending to
+	// the file leaves alone, to tell a replaced or rewritten file apart
+	Fingerprint uint64
+}
+
+// Load the snapshot at the path, a missing snapshot is an empty one so the first run
+// aggregates everything
+func loadSnapshot(path string, scale int) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Snapshot{Scale: scale, Files: make(map[string]SnapshotFile), Values: make(Result)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if snapshot.Scale != scale {
+		return nil, fmt.Errorf("snapshot %s was taken with scale %d, not %d", path, snapshot.Scale, scale)
+	}
+	return snapshot, nil
+}
+
+// Save the snapshot, writing to a temporary file first so a failed run never leaves a
+// half written snapshot behind
+func (s *Snapshot) save(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, s.encode(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Layout of a snapshot, all integers are varints
+//
+//	"1BRS" version
+//	file count, per file: path length, path, offset, fingerprint
+//	the values as a table, which holds the scale
+func (s *Snapshot) encode() []byte {
+	buf := append([]byte{}, snapshotMagic...)
+	buf = binary.AppendUvarint(buf, snapshotVersion)
+
+	buf = binary.AppendUvarint(buf, uint64(len(s.Files)))
+	for path, file := range s.Files {
+		buf = binary.AppendUvarint(buf, uint64(len(path)))
+		buf = append(buf, path...)
+		buf = binary.AppendUvarint(buf, uint64(file.Offset))
+		buf = binary.AppendUvarint(buf, file.Fingerprint)
+	}
+
+	table := &Table{Scale: s.Scale, Values: s.Values}
+	return append(buf, table.encode()...)
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		return nil, errors.New("not a snapshot")
+	}
+	reader := bytes.NewReader(data[len(snapshotMagic):])
+
+	version, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	fileCount, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Files: make(map[string]SnapshotFile)}
+	for range fileCount {
+		path, err := readSnapshotBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Files[string(path)] = SnapshotFile{Offset: int64(offset), Fingerprint: fingerprint}
+	}
+
+	table, err := decodeTable(data[len(data)-reader.Len():])
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Scale, snapshot.Values = table.Scale, table.Values
+	return snapshot, nil
+}
+
+// Read a length prefixed byte slice, checking the length against the remaining data
+func readSnapshotBytes(reader *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(reader, buf)
+	return buf, err
+}
+
+// The file and the section of it that still has to be aggregated
+type sectionFile struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s *sectionFile) Close() error {
+	return s.file.Close()
+}
+
+// Find the end of the last complete line of the file after the offset, reading
+// backwards from the end of the file. A last line without a newline may still be
+// written to, so it's left for a later run
+func lastLineEnd(file *os.File, offset int64, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	end := size
+	for end > offset {
+		start := max(end-int64(len(buf)), offset)
+		block := buf[:end-start]
+		if _, err := file.ReadAt(block, start); err != nil {
+			return 0, err
+		}
+		if idx := bytes.LastIndexByte(block, '\n'); idx >= 0 {
+			return start + int64(idx) + 1, nil
+		}
+		end = start
+	}
+	return offset, nil
+}
+
+// Hash the first bytes of the file up to the offset, at most fingerprintSize of them
+func fingerprint(file *os.File, offset int64) (uint64, error) {
+	buf := make([]byte, min(offset, fingerprintSize))
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return 0, err
+	}
+	hasher := fnv.New64a()
+	hasher.Write(buf)
+	return hasher.Sum64(), nil
+}
+
+// Open the part of the file that hasn't been folded into the snapshot yet, returning
+// how much of it the snapshot holds afterwards. Compressed files can't be read from an
+// offset, so they're either aggregated as a whole or skipped when unchanged
+func openTail(path string, known SnapshotFile) (io.ReadCloser, SnapshotFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, SnapshotFile{}, err
+	}
+	tail, snapshotted, err := openFileTail(file, known)
+	if err != nil {
+		file.Close()
+		return nil, SnapshotFile{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if tail == nil {
+		file.Close()
+	}
+	return tail, snapshotted, nil
+}
+
+// The tail of the opened file for openTail, nil when there's nothing to aggregate
+func openFileTail(file *os.File, known SnapshotFile) (io.ReadCloser, SnapshotFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, SnapshotFile{}, err
+	}
+	size := info.Size()
+	if size < known.Offset {
+		return nil, SnapshotFile{}, errors.New("smaller than when it was snapshotted, it was truncated or replaced")
+	}
+	if known.Offset > 0 {
+		hash, err := fingerprint(file, known.Offset)
+		if err != nil {
+			return nil, SnapshotFile{}, err
+		}
+		if hash != known.Fingerprint {
+			return nil, SnapshotFile{}, errors.New("changed since it was snapshotted, it was replaced or rewritten")
+		}
+	}
+
+	decompressor, err := detectCompression(bufio.NewReader(file))
+	if err != nil {
+		return nil, SnapshotFile{}, err
+	}
+	end := size
+	if decompressor != nil {
+		switch known.Offset {
+		case 0:
+		case size:
+			return nil, known, nil
+		default:
+			return nil, SnapshotFile{}, errors.New("compressed file changed since it was snapshotted")
+		}
+	} else if end, err = lastLineEnd(file, known.Offset, size); err != nil {
+		return nil, SnapshotFile{}, err
+	}
+	if end == known.Offset {
+		return nil, known, nil
+	}
+
+	hash, err := fingerprint(file, end)
+	if err != nil {
+		return nil, SnapshotFile{}, err
+	}
+	snapshotted := SnapshotFile{Offset: end, Fingerprint: hash}
+	if decompressor != nil {
+		return &decompressedFile{PipeReader: decompressAsync(decompressor), file: file}, snapshotted, nil
+	}
+	return &sectionFile{SectionReader: io.NewSectionReader(file, known.Offset, end-known.Offset), file: file}, snapshotted, nil
+}
+
+// Fold the new files and the tails appended to known files into the snapshot at the
+// path using the version, saving the updated snapshot and returning its values. A file
+// listed more than once, like by overlapping globs, is only folded in once
+func aggregateIncremental(snapshotPath string, paths []string, version Version, scale int) (Result, error) {
+	snapshot, err := loadSnapshot(snapshotPath, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	var tails []io.ReadCloser
+	var tailPaths []string
+	files := make(map[string]SnapshotFile, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := files[absPath]; found {
+			continue
+		}
+		tail, file, err := openTail(path, snapshot.Files[absPath])
+		if err != nil {
+			for _, tail := range tails {
+				tail.Close()
+			}
+			return nil, err
+		}
+		files[absPath] = file
+		if tail != nil {
+			tails = append(tails, tail)
+			tailPaths = append(tailPaths, path)
+		}
+	}
+
+	fmt.Printf("Folding %d of %d files into the snapshot\n", len(tails), len(files))
+	results, err := aggregateInputs(tailPaths, func(idx int) (io.ReadCloser, error) {
+		return tails[idx], nil
+	}, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		mergeResult(snapshot.Values, result)
+	}
+	for path, file := range files {
+		snapshot.Files[path] = file
+	}
+
+	if err := snapshot.save(snapshotPath); err != nil {
+		return nil, err
+	}
+	return snapshot.Values, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Folding the appended lines into a snapshot has to give the same values as
+// aggregating the whole file again
+func TestIncrementalMatchesFullRecompute(t *testing.T) {
+	dir := t.TempDir()
+	snapshotPath := filepath.Join(dir, "snapshot.bin")
+	measurements := filepath.Join(dir, "measurements.txt")
+	version, _ := findVersion("V11")
+
+	many := manyMeasurements()
+	// Split in the middle of a line, the partial line has to wait for the next run
+	half := len(many) / 2
+	appends := []string{many[:half], many[half : half+7], many[half+7:]}
+
+	for _, data := range appends {
+		file, err := os.OpenFile(measurements, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(data)
+		file.Close()
+
+		if _, err := aggregateIncremental(snapshotPath, []string{measurements}, version, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, err := loadSnapshot(snapshotPath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := aggregateFiles([]string{measurements}, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := results[0]
+	got, want := formatResult(snapshot.Values, outputOptions), formatResult(full, outputOptions)
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Replaced files can't be folded in, whether they're smaller or not
+	os.WriteFile(measurements, []byte("Hamburg;1.0\n"), 0o644)
+	if _, err := aggregateIncremental(snapshotPath, []string{measurements}, version, 1); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+	os.WriteFile(measurements, []byte("Hamborg"+many[len("Hamburg"):]+"Hamburg;1.0\n"), 0o644)
+	if _, err := aggregateIncremental(snapshotPath, []string{measurements}, version, 1); err == nil {
+		t.Error("expected an error for a rewritten file")
+	}
+}
+
+// The same file listed twice, like by overlapping globs, is only folded in once
+func TestIncrementalDuplicatePaths(t *testing.T) {
+	dir := t.TempDir()
+	measurements := filepath.Join(dir, "measurements.txt")
+	os.WriteFile(measurements, []byte(unixMeasurements), 0o644)
+	version, _ := findVersion("V11")
+
+	relative, err := filepath.Rel(".", measurements)
+	if err != nil {
+		relative = measurements
+	}
+	values, err := aggregateIncremental(filepath.Join(dir, "snapshot.bin"), []string{measurements, relative, measurements}, version, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := values.rows(); rows != 6 {
+		t.Errorf("got %d rows, want 6", rows)
+	}
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	snapshot := &Snapshot{
+		Scale:  2,
+		Files:  map[string]SnapshotFile{"/data/a.txt": {Offset: 1234, Fingerprint: 1 << 63}},
+		Values: Result{cityKey([]byte("Hamburg")): {City: "Hamburg", Min: -120, Max: 3420, Sum: 4520, Count: 3}},
+	}
+	decoded, err := decodeSnapshot(snapshot.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hamburg := decoded.Values[cityKey([]byte("Hamburg"))]
+	if decoded.Scale != 2 || decoded.Files["/data/a.txt"] != snapshot.Files["/data/a.txt"] || hamburg == nil || *hamburg != *snapshot.Values[cityKey([]byte("Hamburg"))] {
+		t.Errorf("got %+v", decoded)
+	}
+
+	data := snapshot.encode()
+	data[len(snapshotMagic)] = snapshotVersion + 1
+	if _, err := decodeSnapshot(data); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+var tableMagic = []byte("1BRT")
+
+const tableVersion = 1
+
+// Counts of the measurements of a city per bucket of Width fixed point units, bucket
+// idx holding the values from idx*Width up to but excluding (idx+1)*Width
+type Histogram struct {
+	Width  int32
+	Counts map[int32]int64
+}
+
+func newHistogram(width int32) *Histogram {
+	return &Histogram{Width: width, Counts: make(map[int32]int64)}
+}
+
+// Count a fixed point value into its bucket, rounding the bucket index down so the
+// negative values get buckets of the same width
+func (h *Histogram) add(val int32) {
+	idx := val / h.Width
+	if val%h.Width < 0 {
+		idx--
+	}
+	h.Counts[idx]++
+}
+
+func (h *Histogram) total() int64 {
+	var total int64
+	for _, count := range h.Counts {
+		total += count
+	}
+	return total
+}
+
+// A station aggregate table, the values of a run along with the scale they were
+// aggregated with so tables can be saved, shipped between processes and merged later
+type Table struct {
+	// Number of fractional digits of the fixed point values
+	Scale  int
+	Values Result
+	// Optional distribution of the measurements, keyed the same as Values. Cities
+	// without a histogram are left out
+	Histograms map[int64]*Histogram
+}
+
+func newTable(scale int) *Table {
+	return &Table{Scale: scale, Values: make(Result), Histograms: make(map[int64]*Histogram)}
+}
+
+// Merge src into dst with the usual min/max/sum/count merge. A histogram is only kept
+// when both tables have one for the city, or dst doesn't have the city yet, as it
+// would be missing measurements otherwise
+func mergeTables(dst *Table, src *Table) error {
+	if dst.Scale != src.Scale {
+		return fmt.Errorf("can't merge a table with scale %d into one with scale %d", src.Scale, dst.Scale)
+	}
+	for key, histogram := range src.Histograms {
+		if dstHistogram, found := dst.Histograms[key]; found && dstHistogram.Width != histogram.Width {
+			return fmt.Errorf("histogram widths %d and %d of %s differ", dstHistogram.Width, histogram.Width, src.Values[key].City)
+		}
+	}
+
+	for key := range src.Values {
+		_, inDst := dst.Values[key]
+		srcHistogram, inSrc := src.Histograms[key]
+		dstHistogram, found := dst.Histograms[key]
+		switch {
+		case !inDst && inSrc:
+			copied := newHistogram(srcHistogram.Width)
+			for idx, count := range srcHistogram.Counts {
+				copied.Counts[idx] = count
+			}
+			dst.Histograms[key] = copied
+		case inDst && found && inSrc:
+			for idx, count := range srcHistogram.Counts {
+				dstHistogram.Counts[idx] += count
+			}
+		case inDst && found:
+			delete(dst.Histograms, key)
+		}
+	}
+	mergeResult(dst.Values, src.Values)
+	return nil
+}
+
+// Layout of a table, all integers are varints
+//
+//	"1BRT" version scale city count
+//	per city: record length, then name length, name, min, max, sum, count
+//	          and optionally histogram width, bucket count, per bucket: index, count
+//
+// The cities are written in byte order of their names so equal tables encode to the
+// same bytes. Every record is length prefixed so a reader knows whether the optional
+// histogram follows the count
+func (t *Table) encode() []byte {
+	buf := append([]byte{}, tableMagic...)
+	buf = binary.AppendUvarint(buf, tableVersion)
+	buf = binary.AppendUvarint(buf, uint64(t.Scale))
+
+	keys := make([]int64, 0, len(t.Values))
+	for key := range t.Values {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b int64) int {
+		return strings.Compare(t.Values[a].City, t.Values[b].City)
+	})
+
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	var record []byte
+	for _, key := range keys {
+		value := t.Values[key]
+		record = binary.AppendUvarint(record[:0], uint64(len(value.City)))
+		record = append(record, value.City...)
+		record = binary.AppendVarint(record, int64(value.Min))
+		record = binary.AppendVarint(record, int64(value.Max))
+		record = binary.AppendVarint(record, value.Sum)
+		record = binary.AppendUvarint(record, uint64(value.Count))
+
+		if histogram, found := t.Histograms[key]; found {
+			buckets := make([]int32, 0, len(histogram.Counts))
+			for idx := range histogram.Counts {
+				buckets = append(buckets, idx)
+			}
+			slices.Sort(buckets)
+
+			record = binary.AppendUvarint(record, uint64(histogram.Width))
+			record = binary.AppendUvarint(record, uint64(len(buckets)))
+			for _, idx := range buckets {
+				record = binary.AppendVarint(record, int64(idx))
+				record = binary.AppendUvarint(record, uint64(histogram.Counts[idx]))
+			}
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(len(record)))
+		buf = append(buf, record...)
+	}
+	return buf
+}
+
+// Read a varint that has to fit into an int32
+func readInt32(reader *bytes.Reader) (int32, error) {
+	val, err := binary.ReadVarint(reader)
+	if err != nil {
+		return 0, err
+	}
+	if val < math.MinInt32 || val > math.MaxInt32 {
+		return 0, fmt.Errorf("%d is out of range", val)
+	}
+	return int32(val), nil
+}
+
+// Read a uvarint that has to be within the limit
+func readUvarint(reader *bytes.Reader, limit uint64) (uint64, error) {
+	val, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, err
+	}
+	if val > limit {
+		return 0, fmt.Errorf("%d is out of range", val)
+	}
+	return val, nil
+}
+
+// Decode a table, checking the values are consistent so a corrupt table is rejected
+// instead of skewing the results it's merged into
+func decodeTable(data []byte) (*Table, error) {
+	if !bytes.HasPrefix(data, tableMagic) {
+		return nil, errors.New("not a table")
+	}
+	reader := bytes.NewReader(data[len(tableMagic):])
+
+	version, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if version != tableVersion {
+		return nil, fmt.Errorf("unsupported table version %d", version)
+	}
+	scale, err := readUvarint(reader, maxScale)
+	if err != nil {
+		return nil, fmt.Errorf("scale: %w", err)
+	}
+	table := newTable(int(scale))
+
+	// Every record takes at least a byte per field, which bounds the count by the
+	// remaining data before allocating anything for it
+	cityCount, err := readUvarint(reader, uint64(reader.Len()))
+	if err != nil {
+		return nil, err
+	}
+	for idx := range cityCount {
+		recordBytes, err := readSnapshotBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := table.decodeRecord(bytes.NewReader(recordBytes)); err != nil {
+			return nil, fmt.Errorf("city %d: %w", idx, err)
+		}
+	}
+
+	if reader.Len() != 0 {
+		return nil, errors.New("trailing data")
+	}
+	return table, nil
+}
+
+func (t *Table) decodeRecord(record *bytes.Reader) error {
+	city, err := readSnapshotBytes(record)
+	if err != nil {
+		return err
+	}
+	key := cityKey(city)
+	if _, found := t.Values[key]; found {
+		return fmt.Errorf("duplicate city %q", city)
+	}
+
+	value := &ValuesV3{City: string(city)}
+	if value.Min, err = readInt32(record); err != nil {
+		return err
+	}
+	if value.Max, err = readInt32(record); err != nil {
+		return err
+	}
+	if value.Sum, err = binary.ReadVarint(record); err != nil {
+		return err
+	}
+	count, err := readUvarint(record, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	value.Count = int32(count)
+	if value.Count == 0 || value.Min > value.Max {
+		return fmt.Errorf("inconsistent values of %q", city)
+	}
+	// The sum of count values between min and max, compared in float64 so the products
+	// can't overflow
+	if sum := float64(value.Sum); sum < float64(value.Min)*float64(value.Count) || sum > float64(value.Max)*float64(value.Count) {
+		return fmt.Errorf("sum of %q is outside of its min and max", city)
+	}
+	t.Values[key] = value
+
+	if record.Len() == 0 {
+		return nil
+	}
+	width, err := readUvarint(record, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	if width == 0 {
+		return fmt.Errorf("histogram of %q has no width", city)
+	}
+	histogram := newHistogram(int32(width))
+	buckets, err := readUvarint(record, uint64(record.Len()))
+	if err != nil {
+		return err
+	}
+	for range buckets {
+		idx, err := readInt32(record)
+		if err != nil {
+			return err
+		}
+		if _, found := histogram.Counts[idx]; found {
+			return fmt.Errorf("duplicate bucket %d of %q", idx, city)
+		}
+		bucketCount, err := readUvarint(record, count)
+		if err != nil {
+			return err
+		}
+		histogram.Counts[idx] = int64(bucketCount)
+	}
+	if histogram.total() != int64(count) {
+		return fmt.Errorf("histogram of %q doesn't add up to its count", city)
+	}
+	if record.Len() != 0 {
+		return errors.New("trailing record data")
+	}
+	t.Histograms[key] = histogram
+	return nil
+}
+
+// Save the table, writing to a temporary file first like the snapshots
+func (t *Table) save(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, t.encode(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := decodeTable(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// Load and merge the tables at the paths
+func mergeTableFiles(paths []string) (*Table, error) {
+	var merged *Table
+	for _, path := range paths {
+		table, err := loadTable(path)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = newTable(table.Scale)
+		}
+		if err := mergeTables(merged, table); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if merged == nil {
+		return nil, errors.New("no tables to merge")
+	}
+	return merged, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A table of the measurements with a histogram of whole degrees per city
+func histogramTable(t testing.TB, input string) *Table {
+	values, _, err := aggregate(strings.NewReader(input), Options{Delimiter: ';', Scale: 1, Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := &Table{Scale: 1, Values: values, Histograms: make(map[int64]*Histogram)}
+	for line := range strings.Lines(input) {
+		city, temp, found := strings.Cut(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), ";")
+		if !found {
+			continue
+		}
+		val, _, ok := parseTemperature([]byte(temp), 1)
+		if key := cityKey([]byte(city)); ok && values[key] != nil {
+			if table.Histograms[key] == nil {
+				table.Histograms[key] = newHistogram(10)
+			}
+			table.Histograms[key].add(val)
+		}
+	}
+	return table
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	table := histogramTable(t, unixMeasurements)
+	decoded, err := decodeTable(table.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, table) {
+		t.Errorf("got %+v, want %+v", decoded, table)
+	}
+
+	hamburg := decoded.Histograms[cityKey([]byte("Hamburg"))]
+	if hamburg.Counts[12] != 1 || hamburg.Counts[-1] != 1 || hamburg.total() != 3 {
+		t.Errorf("got Hamburg histogram %v", hamburg.Counts)
+	}
+
+	// Encoding doesn't depend on the map order
+	if !bytes.Equal(decoded.encode(), table.encode()) {
+		t.Error("encoding the decoded table gave different bytes")
+	}
+}
+
+func TestMergeTables(t *testing.T) {
+	half := strings.Index(unixMeasurements, "Hamburg;3")
+	first, second := histogramTable(t, unixMeasurements[:half]), histogramTable(t, unixMeasurements[half:])
+	whole := histogramTable(t, unixMeasurements)
+
+	merged := newTable(1)
+	for _, table := range []*Table{first, second} {
+		if err := mergeTables(merged, table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(merged, whole) {
+		t.Errorf("got %+v, want %+v", merged, whole)
+	}
+
+	// A city missing its histogram on one side loses it in the merged table
+	delete(second.Histograms, cityKey([]byte("Hamburg")))
+	merged = newTable(1)
+	mergeTables(merged, first)
+	mergeTables(merged, second)
+	if merged.Histograms[cityKey([]byte("Hamburg"))] != nil {
+		t.Error("expected the Hamburg histogram to be dropped")
+	}
+
+	if err := mergeTables(newTable(2), first); err == nil {
+		t.Error("expected an error for different scales")
+	}
+}
+
+// A saved table keeps the cities an exact filter leaves out of the output, so merging
+// it later still gives every city
+func TestSaveKeepsFilteredCities(t *testing.T) {
+	filter := &CityFilter{Include: cityMatcher{names: map[string]bool{"Hamburg": true}}}
+	opts, outputOpts := defaultOptions(), OutputOptions{Scale: 1}
+	filter.apply(&opts, &outputOpts, true)
+	values, _, err := aggregate(strings.NewReader(unixMeasurements), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "monday.tbl")
+	table := newTable(1)
+	table.Values = values
+	if err := table.save(path); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := mergeTableFiles([]string{path, path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Values) != 3 || merged.Values.rows() != 12 {
+		t.Errorf("got %d cities and %d rows, want 3 cities and 12 rows", len(merged.Values), merged.Values.rows())
+	}
+	if got := formatResult(merged.Values, outputOpts); !strings.Contains(got, "Hamburg") || strings.Contains(got, "Bulawayo") {
+		t.Errorf("got %s, want only Hamburg printed", got)
+	}
+}
+
+func FuzzDecodeTable(f *testing.F) {
+	f.Add(histogramTable(f, unixMeasurements).encode())
+	f.Add(newTable(1).encode())
+	f.Add([]byte("1BRT\x01\x01\x01\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		table, err := decodeTable(data)
+		if err != nil {
+			return
+		}
+
+		// Whatever decodes has to survive a round trip unchanged
+		encoded := table.encode()
+		decoded, err := decodeTable(encoded)
+		if err != nil {
+			t.Fatalf("decoding the encoded table: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, table) {
+			t.Errorf("got %+v, want %+v", decoded, table)
+		}
+		if !bytes.Equal(decoded.encode(), encoded) {
+			t.Error("encoding isn't stable")
+		}
+	})
+}
+
+// Merging the decoded tables of two halves of the input gives the table of the whole
+func FuzzMergeMatchesWhole(f *testing.F) {
+	f.Add(unixMeasurements, 20)
+	f.Add(manyMeasurements(), 1000)
+	f.Add("Hamburg;-0.1\nHamburg;0.1\nbad\n", 5)
+
+	f.Fuzz(func(t *testing.T, input string, split int) {
+		// Split after a newline so both halves see the same lines
+		split = min(max(split, 0), len(input))
+		if idx := strings.IndexByte(input[split:], '\n'); idx >= 0 {
+			split += idx + 1
+		} else {
+			split = len(input)
+		}
+
+		merged := newTable(1)
+		for _, part := range []string{input[:split], input[split:]} {
+			decoded, err := decodeTable(histogramTable(t, part).encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mergeTables(merged, decoded); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if whole := histogramTable(t, input); !reflect.DeepEqual(merged, whole) {
+			t.Errorf("got %+v, want %+v", merged, whole)
+		}
+	})
+}
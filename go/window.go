@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of the time buckets the measurements are aggregated in
+type WindowSize int
+
+const (
+	WindowHour WindowSize = iota
+	WindowDay
+	WindowMonth
+)
+
+func parseWindowSize(name string) (WindowSize, error) {
+	switch strings.ToLower(name) {
+	case "hour":
+		return WindowHour, nil
+	case "day":
+		return WindowDay, nil
+	case "month":
+		return WindowMonth, nil
+	}
+	return WindowHour, fmt.Errorf("unknown window %q, expected hour, day or month", name)
+}
+
+// Timestamp layout of epoch seconds, any other layout is a Go time layout
+const unixLayout = "unix"
+
+// Time buckets for measurements with a leading timestamp column, timestamp;city;temp.
+// The buckets follow the wall clock of the location, so days and months start at local
+// midnight shifted by the offset, a day from 06:00 to 06:00 for an offset of 6h
+type Window struct {
+	Size     WindowSize
+	Location *time.Location
+	Offset   time.Duration
+	// Layout the timestamps are parsed with, timestamps without a zone are in the
+	// location
+	Layout string
+}
+
+// Parse the timestamp column of a line
+func (w *Window) parseTimestamp(timestamp []byte) (time.Time, bool) {
+	if w.Layout == unixLayout {
+		seconds, err := strconv.ParseInt(string(timestamp), 10, 64)
+		return time.Unix(seconds, 0), err == nil
+	}
+	parsed, err := time.ParseInLocation(w.Layout, string(timestamp), w.Location)
+	return parsed, err == nil
+}
+
+// Start of the bucket the time falls into
+func (w *Window) start(t time.Time) time.Time {
+	if w.Size == WindowHour {
+		// Dropping the minutes instead of building the hour with time.Date keeps the
+		// repeated hour of a daylight saving time change apart
+		local := t.In(w.Location).Add(-w.Offset)
+		return local.Add(-time.Duration(local.Minute())*time.Minute - time.Duration(local.Second())*time.Second - time.Duration(local.Nanosecond())).Add(w.Offset)
+	}
+
+	// Days and months start at the wall clock offset, 06:00 stays 06:00 across a
+	// daylight saving time change, so step from the bucket of the local date to the
+	// one the time falls into
+	year, month, day := t.In(w.Location).Date()
+	bucket := func(step int) time.Time {
+		if w.Size == WindowMonth {
+			return time.Date(year, month+time.Month(step), 1, 0, 0, 0, int(w.Offset), w.Location)
+		}
+		return time.Date(year, month, day+step, 0, 0, 0, int(w.Offset), w.Location)
+	}
+	step := 0
+	for t.Before(bucket(step)) {
+		step--
+	}
+	for !t.Before(bucket(step + 1)) {
+		step++
+	}
+	return bucket(step)
+}
+
+// Aggregated values per bucket, keyed by the Unix time of the start of the bucket
+type WindowResult map[int64]Result
+
+// Merge the buckets of src into dst with the same merge as mergeResult
+func mergeWindows(dst WindowResult, src WindowResult) {
+	for start, values := range src {
+		if _, found := dst[start]; !found {
+			dst[start] = make(Result, len(values))
+		}
+		mergeResult(dst[start], values)
+	}
+}
+
+// Aggregate the files into time buckets, one file after the other as every file is
+// already spread over the pipeline workers, stopping once the context is cancelled
+func aggregateWindowFiles(ctx context.Context, paths []string, opts Options) (WindowResult, error) {
+	windows := make(WindowResult)
+	for _, path := range paths {
+		file, err := openMeasurements(path)
+		if err != nil {
+			return nil, err
+		}
+		fileWindows, stats, err := aggregateWindows(ctx, file, opts)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if stats.skipped() > 0 {
+			log.Printf("%s: %s", path, stats.summary())
+		}
+		mergeWindows(windows, fileWindows)
+	}
+	return windows, nil
+}
+
+// Build the output of the buckets in chronological order, one line per city and bucket
+// prefixed with the start of the bucket. The cities of every bucket are finalized with
+// the output options, so filters, sorting and ranking apply per bucket
+func formatWindows(windows WindowResult, window *Window, opts OutputOptions) string {
+	starts := make([]int64, 0, len(windows))
+	for start := range windows {
+		starts = append(starts, start)
+	}
+	slices.Sort(starts)
+
+	var output strings.Builder
+	for _, start := range starts {
+		label := time.Unix(start, 0).In(window.Location).Format(time.RFC3339)
+		for _, station := range finalize(windows[start], opts) {
+			fmt.Fprintf(&output, "%s %s=%.*f/%.*f/%.*f\n", label, station.City, opts.Scale, station.Min, opts.Scale, station.Mean, opts.Scale, station.Max)
+		}
+	}
+	return output.String()
+}
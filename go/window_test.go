@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Buckets follow the wall clock of the time zone, including the short day of a
+// daylight saving time change, and are shifted by the offset
+func TestWindowBuckets(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	input := strings.Join([]string{
+		"2026-03-28T22:59:00Z;Hamburg;1.0",
+		"2026-03-28T23:00:00Z;Hamburg;3.0",
+		"2026-03-29T03:59:00+02:00;Hamburg;5.0",
+		"2026-03-29T04:00:00+02:00;Hamburg;7.0",
+		"2026-03-29T12:00:00+02:00;Bulawayo;8.9",
+	}, "\n")
+
+	tests := []struct {
+		window Window
+		want   string
+	}{
+		{Window{Size: WindowDay, Location: berlin, Layout: time.RFC3339}, "" +
+			"2026-03-28T00:00:00+01:00 Hamburg=1.0/1.0/1.0\n" +
+			"2026-03-29T00:00:00+01:00 Bulawayo=8.9/8.9/8.9\n" +
+			"2026-03-29T00:00:00+01:00 Hamburg=3.0/5.0/7.0\n"},
+		{Window{Size: WindowDay, Location: berlin, Offset: 4 * time.Hour, Layout: time.RFC3339}, "" +
+			"2026-03-28T04:00:00+01:00 Hamburg=1.0/3.0/5.0\n" +
+			"2026-03-29T04:00:00+02:00 Bulawayo=8.9/8.9/8.9\n" +
+			"2026-03-29T04:00:00+02:00 Hamburg=7.0/7.0/7.0\n"},
+		{Window{Size: WindowHour, Location: berlin, Layout: time.RFC3339}, "" +
+			"2026-03-28T23:00:00+01:00 Hamburg=1.0/1.0/1.0\n" +
+			"2026-03-29T00:00:00+01:00 Hamburg=3.0/3.0/3.0\n" +
+			"2026-03-29T03:00:00+02:00 Hamburg=5.0/5.0/5.0\n" +
+			"2026-03-29T04:00:00+02:00 Hamburg=7.0/7.0/7.0\n" +
+			"2026-03-29T12:00:00+02:00 Bulawayo=8.9/8.9/8.9\n"},
+		{Window{Size: WindowMonth, Location: time.UTC, Layout: time.RFC3339}, "" +
+			"2026-03-01T00:00:00Z Bulawayo=8.9/8.9/8.9\n" +
+			"2026-03-01T00:00:00Z Hamburg=1.0/4.0/7.0\n"},
+	}
+
+	for _, test := range tests {
+		opts := defaultOptions()
+		opts.Window = &test.window
+		windows, _, err := aggregateWindows(context.Background(), strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatWindows(windows, &test.window, outputOptions); got != test.want {
+			t.Errorf("got\n%swant\n%s", got, test.want)
+		}
+	}
+
+	opts := defaultOptions()
+	opts.Window = &Window{Size: WindowDay, Location: time.UTC, Layout: unixLayout}
+	_, _, err = aggregateWindows(context.Background(), strings.NewReader("1774800000;Hamburg;1.0\nyesterday;Hamburg;2.0\n"), opts)
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Kind != errInvalidTimestamp || lineErr.Line != 2 {
+		t.Errorf("got error %v, want an invalid timestamp on line 2", err)
+	}
+}